@@ -0,0 +1,18 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/provisioner/hosts"
+)
+
+func agentDDL(_ *fisk.ParseContext) error {
+	_, err := os.Stdout.Write(hosts.AgentDDL())
+
+	return err
+}
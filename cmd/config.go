@@ -0,0 +1,31 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/provisioner/config"
+)
+
+func validate(_ *fisk.ParseContext) error {
+	_, errs := config.Validate(cfile)
+
+	fmt.Printf("Validating %s\n\n", cfile)
+
+	if len(errs) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	for _, err := range errs {
+		fmt.Printf("  ✗ %s\n", err)
+	}
+
+	fmt.Println()
+
+	return fmt.Errorf("found %d problem(s) in %s", len(errs), cfile)
+}
@@ -28,6 +28,7 @@ var (
 	cfile   string
 	ccfile  string
 	debug   bool
+	dryRun  bool
 	ctx     context.Context
 	cancel  func()
 	log     *logrus.Entry
@@ -44,6 +45,24 @@ func Run() {
 	cmd.Flag("config", "Configuration file").Required().ExistingFileVar(&cfile)
 	cmd.Flag("choria-config", "Choria configuration file").Default(choria.UserConfig()).ExistingFileVar(&ccfile)
 	cmd.Flag("pid", "Write running PID to a file").StringVar(&pidFile)
+	cmd.Flag("dry-run", "Runs the helper without configuring, restarting, shutting down or upgrading nodes").UnNegatableBoolVar(&dryRun)
+
+	cfgCmd := app.Command("config", "Provisioner configuration utilities")
+	validateCmd := cfgCmd.Command("validate", "Validates a configuration file reporting all problems found").Action(validate)
+	validateCmd.Flag("config", "Configuration file").Required().ExistingFileVar(&cfile)
+
+	agentCmd := app.Command("agent", "Management agent utilities")
+	agentCmd.Command("ddl", "Shows the DDL clients need to interact with the management agent").Action(agentDDL)
+
+	quarantineCmd := app.Command("quarantine", "Manages nodes quarantined after failing too often")
+	quarantineCmd.Flag("config", "Configuration file").Required().ExistingFileVar(&cfile)
+	quarantineCmd.Flag("url", "Address of the management api, defaults to monitor_port on localhost").StringVar(&apiURL)
+
+	qListCmd := quarantineCmd.Command("list", "Lists quarantined nodes and their recent errors").Alias("ls").Action(quarantineList)
+	qListCmd.Flag("json", "Produce JSON output").UnNegatableBoolVar(&jsonOutput)
+
+	qReleaseCmd := quarantineCmd.Command("release", "Releases nodes from quarantine so they are provisioned when found again").Action(quarantineRelease)
+	qReleaseCmd.Arg("identity", "Identities to release").Required().StringsVar(&identities)
 
 	ctx, cancel = context.WithCancel(context.Background())
 	defer cancel()
@@ -55,13 +74,17 @@ func run(_ *fisk.ParseContext) error {
 	cfg, err := config.Load(cfile)
 	fisk.FatalIfError(err, "Provisioning could not be configured: %s", err)
 
+	if dryRun {
+		cfg.DryRun = true
+	}
+
 	ccfg, err := cconf.NewConfig(ccfile)
 	fisk.FatalIfError(err, "Could not load Choria Configuration: %s", err)
 
 	ccfg.LogLevel = cfg.Loglevel
 	ccfg.LogFile = cfg.Logfile
-	ccfg.Collectives = []string{"provisioning"}
-	ccfg.MainCollective = "provisioning"
+	ccfg.Collectives = []string{cfg.Collective}
+	ccfg.MainCollective = cfg.Collective
 
 	if debug {
 		ccfg.LogLevel = "debug"
@@ -83,18 +106,25 @@ func run(_ *fisk.ParseContext) error {
 
 	log = fw.Logger("provisioner")
 
+	stopTracing, err := setupTracing(ctx, cfg.Tracing)
+	fisk.FatalIfError(err, "Could not set up tracing: %s", err)
+	defer stopTracing()
+
+	prov, err := hosts.New(hosts.WithFramework(fw), hosts.WithConfig(cfg))
+	fisk.FatalIfError(err, "Provisioning could not be created: %s", err)
+
 	if cfg.MonitorPort > 0 {
-		go setupPrometheus(cfg.MonitorPort)
+		go setupPrometheus(cfg.MonitorPort, prov)
 	}
 
-	go interruptHandler(ctx, cancel)
+	go interruptHandler(ctx, cancel, cfg, prov)
 
 	if pidFile != "" {
 		writePID(pidFile)
 		defer os.Remove(pidFile)
 	}
 
-	err = hosts.Process(ctx, cfg, fw)
+	err = prov.Run(ctx)
 	fisk.FatalIfError(err, "Provisioning could not start: %s", err)
 
 	return nil
@@ -109,22 +139,52 @@ func writePID(pidfile string) {
 	fisk.FatalIfError(err, "Could not write PID: %s", err)
 }
 
-func interruptHandler(ctx context.Context, cancel func()) {
+// interruptHandler reloads the configuration on SIGHUP and shuts down on SIGINT or SIGTERM, signals keep
+// being handled while draining so a second SIGINT or SIGTERM exits immediately
+func interruptHandler(ctx context.Context, cancel func(), cfg *config.Config, prov *hosts.Provisioner) {
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for sig := range sigs {
+		switch {
+		case sig == syscall.SIGHUP && ctx.Err() == nil:
+			cfg = reload(cfg, prov)
 
-	for {
-		select {
-		case <-sigs:
+		case sig == syscall.SIGHUP:
+			log.Warnf("Not reloading the configuration while shutting down")
+
+		case ctx.Err() == nil:
+			log.Warnf("Shutting down on %s, send it again to exit immediately", sig)
 			cancel()
-		case <-ctx.Done():
-			return
+
+		default:
+			log.Errorf("Exiting immediately on %s while shutting down", sig)
+			os.Exit(1)
 		}
 	}
 }
 
-func setupPrometheus(port int) {
-	log.Infof("Listening for /metrics on %d", port)
+func reload(current *config.Config, prov *hosts.Provisioner) *config.Config {
+	log.Infof("Reloading configuration from %s", current.File)
+
+	cfg, restart, err := config.Reload(current)
+	if err != nil {
+		log.Errorf("Could not reload configuration, continuing with previous configuration: %s", err)
+		return current
+	}
+
+	for _, setting := range restart {
+		log.Warnf("Changing %s requires a restart, continuing with previous value", setting)
+	}
+
+	prov.Reconfigure(cfg)
+
+	return cfg
+}
+
+func setupPrometheus(port int, prov *hosts.Provisioner) {
+	log.Infof("Listening for /metrics and /api/v1 on %d", port)
 	http.Handle("/metrics", promhttp.Handler())
+	prov.RegisterAPI(http.DefaultServeMux)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
 }
@@ -0,0 +1,158 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/provisioner/config"
+	"github.com/choria-io/provisioner/hosts"
+)
+
+var (
+	apiURL     string
+	jsonOutput bool
+	identities []string
+)
+
+// apiClient talks to the management API of the provisioner configured in a configuration file
+type apiClient struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func newAPIClient(file string) (*apiClient, error) {
+	cfg, err := config.Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not load configuration: %s", err)
+	}
+
+	if cfg.ManagementToken == "" {
+		return nil, fmt.Errorf("the management api is disabled, set management_token to enable")
+	}
+
+	base := apiURL
+	if base == "" {
+		if cfg.MonitorPort == 0 {
+			return nil, fmt.Errorf("monitor_port is not set, use --url to set the management api address")
+		}
+
+		base = fmt.Sprintf("http://localhost:%d", cfg.MonitorPort)
+	}
+
+	return &apiClient{
+		url:   strings.TrimSuffix(base, "/"),
+		token: cfg.ManagementToken,
+		http:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// request performs a request and decodes the JSON response into out
+func (c *apiClient) request(method string, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("management api request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read management api response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+
+		return fmt.Errorf("management api request failed: %s", resp.Status)
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+func quarantineList(_ *fisk.ParseContext) error {
+	client, err := newAPIClient(cfile)
+	if err != nil {
+		return err
+	}
+
+	var entries []hosts.QuarantineEntry
+
+	err = client.request(http.MethodGet, "/api/v1/quarantine", &entries)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No nodes are quarantined")
+		return nil
+	}
+
+	for _, e := range entries {
+		fmt.Printf("%s quarantined at %s after %d failures\n\n", e.Identity, e.Since.Format(time.RFC3339), e.Failures)
+		for _, f := range e.Errors {
+			fmt.Printf("  %s %s\n", f.Time.Format(time.RFC3339), f.Error)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func quarantineRelease(_ *fisk.ParseContext) error {
+	client, err := newAPIClient(cfile)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+
+	for _, identity := range identities {
+		var res struct {
+			Message string `json:"message"`
+		}
+
+		err := client.request(http.MethodDelete, "/api/v1/quarantine/"+url.PathEscape(identity), &res)
+		if err != nil {
+			fmt.Printf("  ✗ %s: %s\n", identity, err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("  ✓ %s %s\n", identity, res.Message)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("could not release %d node(s)", failed)
+	}
+
+	return nil
+}
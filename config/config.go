@@ -10,11 +10,14 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/go-choria/client/client"
+	"github.com/choria-io/go-choria/protocol"
 	"github.com/ghodss/yaml"
 )
 
@@ -30,9 +33,14 @@ type Config struct {
 	Helper                  string   `json:"helper"`
 	Token                   string   `json:"token"`
 	LifecycleComponent      string   `json:"lifecycle_component"`
+	LifecycleComponents     []string `json:"lifecycle_components"`
+	LifecycleEvents         []string `json:"lifecycle_events"`
+	Collective              string   `json:"collective"`
 	Insecure                bool     `json:"choria_insecure"`
 	Site                    string   `json:"site"`
 	MonitorPort             int      `json:"monitor_port"`
+	ManagementToken         string   `json:"management_token"`
+	ManagementAgent         bool     `json:"management_agent"`
 	BrokerPort              int      `json:"broker_port"`
 	BrokerProvisionPassword string   `json:"broker_provisioning_password"`
 	CertDenyList            []string `json:"cert_deny_list"`
@@ -44,6 +52,22 @@ type Config struct {
 	LeaderElection          bool     `json:"leader_election"`
 	UpgradesRepo            string   `json:"upgrades_repository"`
 	UpgradesOptional        bool     `json:"upgrades_optional"`
+	PauseStateFile          string   `json:"pause_state_file"`
+	PauseStateBucket        string   `json:"pause_state_bucket"`
+	QueueDirectory          string   `json:"queue_directory"`
+	QueueBucket             string   `json:"queue_bucket"`
+	QueueOrder              []string `json:"queue_order"`
+	QuarantineThreshold     int      `json:"quarantine_threshold"`
+	QuarantineBucket        string   `json:"quarantine_bucket"`
+	QuarantineExpiry        string   `json:"quarantine_expiry"`
+	DryRun                  bool     `json:"dry_run"`
+	DryRunDirectory         string   `json:"dry_run_directory"`
+	AuditLog                string   `json:"audit_log"`
+	EventsSubject           string   `json:"events_subject"`
+
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows"`
+	DiscoveryFilter    DiscoveryFilter     `json:"discovery_filter"`
+	DiscoverySources   []DiscoverySource   `json:"discovery_sources"`
 
 	Features struct {
 		PKI             bool `json:"pki"`
@@ -52,21 +76,189 @@ type Config struct {
 		VersionUpgrades bool `json:"upgrades"`
 	} `json:"features"`
 
+	Timeouts Timeouts `json:"timeouts"`
+	Retries  Retries  `json:"retries"`
+	Backoff  Backoff  `json:"backoff"`
+	Priority Priority `json:"priority"`
+	Sharding Sharding `json:"sharding"`
+	Locks    Locks    `json:"locks"`
+	Webhooks Webhooks `json:"webhooks"`
+	Tracing  Tracing  `json:"tracing"`
+
 	ServerJWTValidityDuration time.Duration `json:"-"`
 	IntervalDuration          time.Duration `json:"-"`
+	QuarantineExpiryDuration  time.Duration `json:"-"`
 	File                      string        `json:"-"`
 
-	paused bool
+	pause *pauseState
 	sync.Mutex
 }
 
+// Timeouts configures how long various provisioning steps can take
+type Timeouts struct {
+	// Helper is how long the helper can run
+	Helper string `json:"helper"`
+	// Discovery is how long broadcast discovery waits for replies
+	Discovery string `json:"discovery"`
+	// Cooldown is how long a provisioned node is kept on the hosts list to avoid provisioning it again
+	Cooldown string `json:"cooldown"`
+	// Stale is how long a node can wait to be provisioned before it is skipped, defaults to twice the interval
+	Stale string `json:"stale"`
+	// Drain is how long nodes being provisioned can take to finish when shutting down
+	Drain string `json:"drain"`
+
+	HelperDuration    time.Duration `json:"-"`
+	DiscoveryDuration time.Duration `json:"-"`
+	CooldownDuration  time.Duration `json:"-"`
+	StaleDuration     time.Duration `json:"-"`
+	DrainDuration     time.Duration `json:"-"`
+}
+
+// Retries configures how many times each RPC request is tried
+type Retries struct {
+	JWT       int `json:"jwt"`
+	ED25519   int `json:"gen25519"`
+	Inventory int `json:"inventory"`
+	CSR       int `json:"gencsr"`
+	Configure int `json:"configure"`
+	Restart   int `json:"restart"`
+	Shutdown  int `json:"shutdown"`
+	Upgrade   int `json:"release_update"`
+}
+
+// DiscoveryFilter restricts the nodes that are provisioned using the usual Choria filter syntax, nodes have to match all filters
+type DiscoveryFilter struct {
+	Facts      []string `json:"facts"`
+	Classes    []string `json:"classes"`
+	Identities []string `json:"identities"`
+	Compound   string   `json:"compound"`
+}
+
+// Empty is true when no filters are set
+func (f DiscoveryFilter) Empty() bool {
+	return len(f.Facts) == 0 && len(f.Classes) == 0 && len(f.Identities) == 0 && f.Compound == ""
+}
+
+// Filter creates a Choria filter matching nodes with agent and the configured filters
+func (f DiscoveryFilter) Filter(agent string) (*protocol.Filter, error) {
+	return client.NewFilter(
+		client.AgentFilter(agent),
+		client.FactFilter(f.Facts...),
+		client.ClassFilter(f.Classes...),
+		client.IdentityFilter(f.Identities...),
+		client.CompoundFilter(f.Compound),
+	)
+}
+
+// Backoff configures how failed nodes are provisioned again, the delay doubles after every failed attempt
+type Backoff struct {
+	// MaxAttempts is how many times provisioning a node is attempted before giving up until it is found again
+	MaxAttempts int `json:"max_attempts"`
+	// Initial is the delay before the first retry
+	Initial string `json:"initial"`
+	// Max is the longest delay between attempts
+	Max string `json:"max"`
+
+	InitialDuration time.Duration `json:"-"`
+	MaxDuration     time.Duration `json:"-"`
+}
+
+// Delay is how long to wait before the attempt following the failed attempt number attempt
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.InitialDuration
+	for i := 1; i < attempt && delay < b.MaxDuration; i++ {
+		delay *= 2
+	}
+
+	return min(delay, b.MaxDuration)
+}
+
+// Priority configures where the provisioning priority of a node is found, nodes with higher priorities are provisioned first
+type Priority struct {
+	// Fact is a GJSON path to the priority in the node facts
+	Fact string `json:"fact"`
+	// JWTExtension is the key holding the priority in the extensions claim of the provisioning JWT, it is preferred over Fact
+	JWTExtension string `json:"jwt_extension"`
+}
+
+// Sharding splits the nodes between several active provisioners using consistent hashing of their identities
+type Sharding struct {
+	// Bucket is the Key-Value bucket instances record their membership in, sharding is enabled when set
+	Bucket string `json:"bucket"`
+	// Heartbeat is how often instances renew their membership, instances that did not renew for 3 heartbeats are considered gone
+	Heartbeat string `json:"heartbeat"`
+
+	HeartbeatDuration time.Duration `json:"-"`
+}
+
+// Enabled determines if nodes are sharded between instances
+func (s Sharding) Enabled() bool {
+	return s.Bucket != ""
+}
+
+// Locks configures leases on nodes so several provisioners never provision the same node at the same time
+type Locks struct {
+	// Bucket is the Key-Value bucket holding the locks, locking is enabled when set
+	Bucket string `json:"bucket"`
+	// TTL is how long a lock is held without being renewed, locks are renewed every third of the TTL while provisioning
+	TTL string `json:"ttl"`
+
+	TTLDuration time.Duration `json:"-"`
+}
+
+// Enabled determines if nodes are locked while being provisioned
+func (l Locks) Enabled() bool {
+	return l.Bucket != ""
+}
+
+// Keys the work queue can be ordered by
+const (
+	// QueueOrderPriority orders nodes with a higher priority first
+	QueueOrderPriority = "priority"
+	// QueueOrderEvent orders nodes that published a startup event before discovered ones
+	QueueOrderEvent = "event"
+	// QueueOrderOldest orders nodes that were found earlier first
+	QueueOrderOldest = "oldest"
+)
+
+// Lifecycle events the provisioner acts on
+const (
+	// LifecycleStartup adds nodes that started in provisioning mode to the work queue
+	LifecycleStartup = "startup"
+	// LifecycleShutdown removes nodes that shut down from the work queue
+	LifecycleShutdown = "shutdown"
+	// LifecycleAlive adds nodes that are still waiting in provisioning mode to the work queue
+	LifecycleAlive = "alive"
+)
+
 // Load reads configuration from a YAML file
 func Load(file string) (*Config, error) {
+	config, err := parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := config.validate()
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	pausedGauge.WithLabelValues(config.Site).Set(0)
+
+	return config, nil
+}
+
+// parse reads file and sets defaults without performing any validation
+func parse(file string) (*Config, error) {
 	config := &Config{
 		LifecycleComponent: "provision_mode_server",
+		Collective:         "provisioning",
 		Interval:           "1m",
 		Logfile:            "info",
 		File:               file,
+		// retries are set before parsing so an explicit 0 fails validation rather than using the default
+		Retries: Retries{JWT: 3, ED25519: 5, Inventory: 5, CSR: 1, Configure: 5, Restart: 3, Shutdown: 3, Upgrade: 3},
+		Backoff: Backoff{MaxAttempts: 5},
 	}
 
 	if _, err := os.Stat(file); os.IsNotExist(err) {
@@ -92,12 +284,21 @@ func Load(file string) (*Config, error) {
 		config.LifecycleComponent = "provision_mode_server"
 	}
 
-	if config.Workers == 0 {
-		config.Workers = runtime.NumCPU()
+	if len(config.LifecycleComponents) == 0 {
+		config.LifecycleComponents = []string{config.LifecycleComponent}
+	}
+
+	// shutdown and alive events have to be enabled explicitly as earlier versions only acted on startup events
+	if len(config.LifecycleEvents) == 0 {
+		config.LifecycleEvents = []string{LifecycleStartup}
 	}
 
-	if strings.Contains(config.LifecycleComponent, ".") || strings.Contains(config.LifecycleComponent, ">") || strings.Contains(config.LifecycleComponent, "*") {
-		return nil, fmt.Errorf("invalid lifecycle component: %s", config.LifecycleComponent)
+	if config.Collective == "" {
+		config.Collective = "provisioning"
+	}
+
+	if config.Workers == 0 {
+		config.Workers = runtime.NumCPU()
 	}
 
 	if len(config.CertDenyList) == 0 {
@@ -109,34 +310,284 @@ func Load(file string) (*Config, error) {
 		}
 	}
 
-	if config.Features.PKI && config.Features.ED25519 {
-		return nil, fmt.Errorf("can only enable one of pki or ed25519 features")
-	}
-
 	if config.Features.ED25519 {
 		config.Features.JWT = true
 	}
 
-	config.IntervalDuration, err = choria.ParseDuration(config.Interval)
-	if err != nil {
-		return nil, fmt.Errorf("invalid duration: %s", err)
+	if len(config.DiscoverySources) == 0 {
+		config.DiscoverySources = []DiscoverySource{{Type: DiscoveryBroadcast}}
+	}
+
+	if len(config.QueueOrder) == 0 {
+		config.QueueOrder = []string{QueueOrderPriority, QueueOrderEvent, QueueOrderOldest}
 	}
 
 	if config.ServerJWTValidity == "" {
 		config.ServerJWTValidity = "1y"
-		config.ServerJWTValidityDuration = 365 * 24 * time.Hour
+	}
+
+	config.setTimeoutDefaults()
+
+	return config, nil
+}
+
+func (c *Config) setTimeoutDefaults() {
+	setDefault := func(v *string, d string) {
+		if *v == "" {
+			*v = d
+		}
+	}
+
+	setDefault(&c.Timeouts.Helper, "10s")
+	setDefault(&c.Timeouts.Discovery, "2s")
+	setDefault(&c.Timeouts.Cooldown, "60s")
+	setDefault(&c.Timeouts.Drain, "1m")
+	setDefault(&c.Backoff.Initial, "10s")
+	setDefault(&c.Backoff.Max, "5m")
+	setDefault(&c.Sharding.Heartbeat, "10s")
+	setDefault(&c.Locks.TTL, "1m")
+	setDefault(&c.QuarantineExpiry, "24h")
+}
+
+// validateTimeoutsAndRetries parses the timeouts, it should be called after the interval was parsed
+func (c *Config) validateTimeoutsAndRetries() []error {
+	var (
+		errs []error
+		err  error
+	)
+
+	parse := func(name string, v string, min time.Duration) time.Duration {
+		d, err := choria.ParseDuration(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid timeouts.%s duration: %s", name, err))
+			return 0
+		}
+
+		if d < min {
+			errs = append(errs, fmt.Errorf("timeouts.%s should be at least %v", name, min))
+		}
+
+		return d
+	}
+
+	c.Timeouts.HelperDuration = parse("helper", c.Timeouts.Helper, time.Second)
+	c.Timeouts.DiscoveryDuration = parse("discovery", c.Timeouts.Discovery, time.Second)
+	c.Timeouts.CooldownDuration = parse("cooldown", c.Timeouts.Cooldown, 0)
+	c.Timeouts.DrainDuration = parse("drain", c.Timeouts.Drain, 0)
+
+	if c.Timeouts.Stale == "" {
+		c.Timeouts.StaleDuration = 2 * c.IntervalDuration
 	} else {
-		config.ServerJWTValidityDuration, err = choria.ParseDuration(config.ServerJWTValidity)
+		c.Timeouts.StaleDuration = parse("stale", c.Timeouts.Stale, time.Minute)
+	}
+
+	tries := []struct {
+		name  string
+		tries int
+	}{
+		{"jwt", c.Retries.JWT},
+		{"gen25519", c.Retries.ED25519},
+		{"inventory", c.Retries.Inventory},
+		{"gencsr", c.Retries.CSR},
+		{"configure", c.Retries.Configure},
+		{"restart", c.Retries.Restart},
+		{"shutdown", c.Retries.Shutdown},
+		{"release_update", c.Retries.Upgrade},
+	}
+
+	for _, t := range tries {
+		if t.tries < 1 {
+			errs = append(errs, fmt.Errorf("retries.%s should be at least 1", t.name))
+		}
+	}
+
+	c.Backoff.InitialDuration, err = choria.ParseDuration(c.Backoff.Initial)
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("invalid backoff.initial duration: %s", err))
+	case c.Backoff.InitialDuration < time.Second:
+		errs = append(errs, fmt.Errorf("backoff.initial should be at least 1s"))
+	}
+
+	c.Backoff.MaxDuration, err = choria.ParseDuration(c.Backoff.Max)
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("invalid backoff.max duration: %s", err))
+	case c.Backoff.MaxDuration < c.Backoff.InitialDuration:
+		errs = append(errs, fmt.Errorf("backoff.max should be at least backoff.initial"))
+	}
+
+	if c.Backoff.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("backoff.max_attempts should be at least 1"))
+	}
+
+	c.Sharding.HeartbeatDuration, err = choria.ParseDuration(c.Sharding.Heartbeat)
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("invalid sharding.heartbeat duration: %s", err))
+	case c.Sharding.HeartbeatDuration < time.Second:
+		errs = append(errs, fmt.Errorf("sharding.heartbeat should be at least 1s"))
+	}
+
+	c.Locks.TTLDuration, err = choria.ParseDuration(c.Locks.TTL)
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("invalid locks.ttl duration: %s", err))
+	case c.Locks.TTLDuration < 10*time.Second:
+		errs = append(errs, fmt.Errorf("locks.ttl should be at least 10s"))
+	}
+
+	c.QuarantineExpiryDuration, err = choria.ParseDuration(c.QuarantineExpiry)
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("invalid quarantine_expiry duration: %s", err))
+	case c.QuarantineExpiryDuration < time.Minute:
+		errs = append(errs, fmt.Errorf("quarantine_expiry should be at least 1m"))
+	}
+
+	return errs
+}
+
+// validate checks the settings Load requires to be valid and parses durations, all problems found are returned
+func (c *Config) validate() []error {
+	var (
+		errs []error
+		err  error
+	)
+
+	for i, component := range c.LifecycleComponents {
+		if component == "" || strings.ContainsAny(component, ".>* ") {
+			errs = append(errs, fmt.Errorf("invalid lifecycle component: %s", component))
+		} else if slices.Contains(c.LifecycleComponents[:i], component) {
+			errs = append(errs, fmt.Errorf("duplicate lifecycle component: %s", component))
+		}
+	}
+
+	if strings.ContainsAny(c.EventsSubject, ">* ") || strings.HasPrefix(c.EventsSubject, ".") || strings.HasSuffix(c.EventsSubject, ".") {
+		errs = append(errs, fmt.Errorf("invalid events_subject: %s", c.EventsSubject))
+	}
+
+	for _, event := range c.LifecycleEvents {
+		if !slices.Contains([]string{LifecycleStartup, LifecycleShutdown, LifecycleAlive}, event) {
+			errs = append(errs, fmt.Errorf("invalid lifecycle event %q, valid events are %s, %s and %s", event, LifecycleStartup, LifecycleShutdown, LifecycleAlive))
+		}
+	}
+
+	if c.Collective == "" || strings.ContainsAny(c.Collective, ".>* ") {
+		errs = append(errs, fmt.Errorf("invalid collective: %s", c.Collective))
+	}
+
+	if c.Features.PKI && c.Features.ED25519 {
+		errs = append(errs, fmt.Errorf("can only enable one of pki or ed25519 features"))
+	}
+
+	c.IntervalDuration, err = choria.ParseDuration(c.Interval)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid duration: %s", err))
+	} else if c.IntervalDuration < time.Minute {
+		errs = append(errs, errors.New("interval is too small, minmum is 1 minute.  Valid example values are 10m or 10h"))
+	}
+
+	c.ServerJWTValidityDuration, err = choria.ParseDuration(c.ServerJWTValidity)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid server jwt validity duration: %s", err))
+	}
+
+	if c.PauseStateFile != "" && c.PauseStateBucket != "" {
+		errs = append(errs, fmt.Errorf("can only set one of pause_state_file or pause_state_bucket"))
+	}
+
+	if c.PauseStateBucket != "" && !c.LeaderElection {
+		errs = append(errs, fmt.Errorf("pause_state_bucket requires leader_election"))
+	}
+
+	if c.Sharding.Enabled() && c.LeaderElection {
+		errs = append(errs, fmt.Errorf("can only enable one of sharding or leader_election"))
+	}
+
+	if c.QueueDirectory != "" && c.QueueBucket != "" {
+		errs = append(errs, fmt.Errorf("can only set one of queue_directory or queue_bucket"))
+	}
+
+	order := make(map[string]bool)
+	for _, key := range c.QueueOrder {
+		switch {
+		case key != QueueOrderPriority && key != QueueOrderEvent && key != QueueOrderOldest:
+			errs = append(errs, fmt.Errorf("invalid queue_order key %q, valid keys are %s, %s and %s", key, QueueOrderPriority, QueueOrderEvent, QueueOrderOldest))
+		case order[key]:
+			errs = append(errs, fmt.Errorf("duplicate queue_order key %q", key))
+		}
+		order[key] = true
+	}
+
+	_, err = c.DiscoveryFilter.Filter("choria_provision")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid discovery_filter: %s", err))
+	}
+
+	if c.QuarantineThreshold < 0 {
+		errs = append(errs, fmt.Errorf("quarantine_threshold can not be negative"))
+	}
+
+	if c.Priority.JWTExtension != "" && !c.Features.JWT {
+		errs = append(errs, fmt.Errorf("priority.jwt_extension requires the jwt feature"))
+	}
+
+	sources := make(map[string]bool)
+	for i := range c.DiscoverySources {
+		s := &c.DiscoverySources[i]
+
+		err := s.prepare()
 		if err != nil {
-			return nil, fmt.Errorf("invalid server jwt validity duration: %s", err)
+			errs = append(errs, fmt.Errorf("invalid discovery source %d: %s", i+1, err))
+			continue
 		}
+
+		if sources[s.Name] {
+			errs = append(errs, fmt.Errorf("duplicate discovery source %q", s.Name))
+		}
+		sources[s.Name] = true
 	}
 
-	if config.IntervalDuration < time.Minute {
-		return nil, errors.New("interval is too small, minmum is 1 minute.  Valid example values are 10m or 10h")
+	names := make(map[string]bool)
+	for i := range c.MaintenanceWindows {
+		w := &c.MaintenanceWindows[i]
+
+		err := w.prepare()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid maintenance window %d: %s", i+1, err))
+			continue
+		}
+
+		if names[w.Name] {
+			errs = append(errs, fmt.Errorf("duplicate maintenance window %q", w.Name))
+		}
+		names[w.Name] = true
 	}
 
-	pausedGauge.WithLabelValues(config.Site).Set(0)
+	errs = append(errs, c.Webhooks.prepare()...)
 
-	return config, nil
+	err = c.Tracing.prepare()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, c.validateTimeoutsAndRetries()...)
+
+	return errs
+}
+
+// UsesPriority determines if the work queue is ordered by a priority that has to be fetched from nodes
+func (c *Config) UsesPriority() bool {
+	if c.Priority.Fact == "" && c.Priority.JWTExtension == "" {
+		return false
+	}
+
+	for _, key := range c.QueueOrder {
+		if key == QueueOrderPriority {
+			return true
+		}
+	}
+
+	return false
 }
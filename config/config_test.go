@@ -0,0 +1,583 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/tokens"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config")
+}
+
+var _ = Describe("Config", func() {
+	var (
+		td   string
+		file string
+	)
+
+	writeConfig := func(cfg string) {
+		Expect(os.WriteFile(file, []byte(cfg), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		td = GinkgoT().TempDir()
+		file = filepath.Join(td, "provisioner.yaml")
+	})
+
+	Describe("Load", func() {
+		It("Should set defaults", func() {
+			writeConfig("helper: /bin/true\n")
+
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.LifecycleComponent).To(Equal("provision_mode_server"))
+			Expect(cfg.LifecycleComponents).To(Equal([]string{"provision_mode_server"}))
+			Expect(cfg.LifecycleEvents).To(Equal([]string{"startup"}))
+			Expect(cfg.IntervalDuration).To(Equal(time.Minute))
+			Expect(cfg.ServerJWTValidityDuration).To(Equal(365 * 24 * time.Hour))
+			Expect(cfg.CertDenyList).To(HaveLen(4))
+			Expect(cfg.Workers).To(BeNumerically(">", 0))
+			Expect(cfg.Timeouts.HelperDuration).To(Equal(10 * time.Second))
+			Expect(cfg.Timeouts.DiscoveryDuration).To(Equal(2 * time.Second))
+			Expect(cfg.Timeouts.CooldownDuration).To(Equal(time.Minute))
+			Expect(cfg.Timeouts.StaleDuration).To(Equal(2 * time.Minute))
+			Expect(cfg.Timeouts.DrainDuration).To(Equal(time.Minute))
+			Expect(cfg.Retries).To(Equal(Retries{JWT: 3, ED25519: 5, Inventory: 5, CSR: 1, Configure: 5, Restart: 3, Shutdown: 3, Upgrade: 3}))
+			Expect(cfg.QueueOrder).To(Equal([]string{"priority", "event", "oldest"}))
+			Expect(cfg.Collective).To(Equal("provisioning"))
+			Expect(cfg.DiscoveryFilter.Empty()).To(BeTrue())
+			Expect(cfg.DiscoverySources).To(Equal([]DiscoverySource{{Name: "broadcast", Type: "broadcast"}}))
+			Expect(cfg.Backoff.MaxAttempts).To(Equal(5))
+			Expect(cfg.Backoff.InitialDuration).To(Equal(10 * time.Second))
+			Expect(cfg.Backoff.MaxDuration).To(Equal(5 * time.Minute))
+			Expect(cfg.UsesPriority()).To(BeFalse())
+			Expect(cfg.Sharding.Enabled()).To(BeFalse())
+			Expect(cfg.Sharding.HeartbeatDuration).To(Equal(10 * time.Second))
+			Expect(cfg.Locks.Enabled()).To(BeFalse())
+			Expect(cfg.Locks.TTLDuration).To(Equal(time.Minute))
+			Expect(cfg.QuarantineExpiryDuration).To(Equal(24 * time.Hour))
+		})
+
+		It("Should support custom timeouts and retries", func() {
+			writeConfig("interval: 5m\ntimeouts:\n  helper: 30s\n  discovery: 10s\n  cooldown: 0s\n  stale: 1h\nretries:\n  configure: 10\n")
+
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Timeouts.HelperDuration).To(Equal(30 * time.Second))
+			Expect(cfg.Timeouts.DiscoveryDuration).To(Equal(10 * time.Second))
+			Expect(cfg.Timeouts.CooldownDuration).To(Equal(time.Duration(0)))
+			Expect(cfg.Timeouts.StaleDuration).To(Equal(time.Hour))
+			Expect(cfg.Retries.Configure).To(Equal(10))
+			Expect(cfg.Retries.JWT).To(Equal(3))
+		})
+
+		It("Should validate timeouts and retries", func() {
+			writeConfig("timeouts:\n  helper: 10ms\n  discovery: x\nretries:\n  restart: -1\n")
+
+			_, errs := Validate(file)
+			Expect(errs).To(ContainElements(
+				MatchError("timeouts.helper should be at least 1s"),
+				MatchError(ContainSubstring("invalid timeouts.discovery duration")),
+				MatchError("retries.restart should be at least 1"),
+			))
+		})
+
+		It("Should not treat explicit zero retries as unset", func() {
+			writeConfig("retries:\n  configure: 0\nbackoff:\n  max_attempts: 0\n")
+
+			_, errs := Validate(file)
+			Expect(errs).To(ContainElements(
+				MatchError("retries.configure should be at least 1"),
+				MatchError("backoff.max_attempts should be at least 1"),
+			))
+			Expect(errs).ToNot(ContainElement(MatchError("retries.jwt should be at least 1")))
+		})
+
+		It("Should detect invalid settings", func() {
+			writeConfig("interval: 10s\n")
+			_, err := Load(file)
+			Expect(err).To(MatchError(ContainSubstring("interval is too small")))
+
+			writeConfig("lifecycle_component: x.y\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("invalid lifecycle component: x.y"))
+
+			writeConfig("lifecycle_components: [provision_mode_server, provision_mode_server]\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("duplicate lifecycle component: provision_mode_server"))
+
+			writeConfig("events_subject: choria.provisioner.>\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("invalid events_subject: choria.provisioner.>"))
+
+			writeConfig("lifecycle_events: [startup, provisioned]\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(ContainSubstring(`invalid lifecycle event "provisioned"`)))
+
+			writeConfig("features:\n  pki: true\n  ed25519: true\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("can only enable one of pki or ed25519 features"))
+
+			writeConfig("queue_directory: /tmp/queue\nqueue_bucket: QUEUE\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(ContainSubstring("can only set one of queue_directory or queue_bucket")))
+
+			writeConfig("discovery_filter:\n  facts: [dc]\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(ContainSubstring("invalid discovery_filter")))
+
+			writeConfig("collective: dc1.provisioning\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("invalid collective: dc1.provisioning"))
+
+			writeConfig("quarantine_threshold: -1\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("quarantine_threshold can not be negative"))
+
+			writeConfig("quarantine_expiry: 10s\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("quarantine_expiry should be at least 1m"))
+
+			writeConfig("backoff:\n  initial: 1m\n  max: 10s\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("backoff.max should be at least backoff.initial"))
+
+			writeConfig("backoff:\n  initial: 0s\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("backoff.initial should be at least 1s"))
+
+			writeConfig("backoff:\n  initial: 100ms\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("backoff.initial should be at least 1s"))
+
+			writeConfig("queue_order: [oldest, size, oldest]\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(ContainSubstring(`invalid queue_order key "size"`)))
+
+			writeConfig("discovery_sources:\n  - type: ldap\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(ContainSubstring(`invalid discovery source 1: invalid type "ldap"`)))
+
+			writeConfig("discovery_sources:\n  - type: http\n    url: ftp://inventory.example.net/\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("invalid discovery source 1: a http or https url is required"))
+
+			writeConfig("discovery_sources:\n  - type: file\n    path: /etc/nodes\n  - type: file\n    path: /etc/more_nodes\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(`duplicate discovery source "file"`))
+
+			writeConfig("leader_election: true\nsharding:\n  bucket: MEMBERS\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("can only enable one of sharding or leader_election"))
+
+			writeConfig("sharding:\n  bucket: MEMBERS\n  heartbeat: 100ms\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("sharding.heartbeat should be at least 1s"))
+
+			writeConfig("locks:\n  bucket: LOCKS\n  ttl: 1s\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("locks.ttl should be at least 10s"))
+
+			writeConfig("webhooks:\n  endpoints:\n    - url: https://hooks.example.net/\n      outcomes: [deferred]\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(ContainSubstring(`invalid webhook endpoint 1: invalid outcome "deferred"`)))
+
+			writeConfig("webhooks:\n  endpoints:\n    - url: https://hooks.example.net/one\n    - url: https://hooks.example.net/two\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(`duplicate webhook endpoint "hooks.example.net"`))
+
+			writeConfig("tracing:\n  exporter: jaeger\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(`invalid tracing.exporter "jaeger", valid exporters are otlp, file and stdout`))
+
+			writeConfig("tracing:\n  exporter: file\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("tracing.file is required for the file exporter"))
+
+			writeConfig("priority:\n  jwt_extension: priority\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError("priority.jwt_extension requires the jwt feature"))
+		})
+	})
+
+	Describe("DiscoveryFilter", func() {
+		It("Should create a filter", func() {
+			df := DiscoveryFilter{Facts: []string{"dc=dc1"}, Classes: []string{"role::db"}, Identities: []string{"/^db/"}, Compound: "with('hardware=dell')"}
+			Expect(df.Empty()).To(BeFalse())
+
+			f, err := df.Filter("choria_provision")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.AgentFilters()).To(Equal([]string{"choria_provision"}))
+			Expect(f.ClassFilters()).To(Equal([]string{"role::db"}))
+			Expect(f.IdentityFilters()).To(Equal([]string{"/^db/"}))
+			Expect(f.FactFilters()).To(HaveLen(1))
+			Expect(f.CompoundFilters()).To(HaveLen(1))
+		})
+	})
+
+	Describe("Webhooks", func() {
+		It("Should set webhook defaults", func() {
+			writeConfig("webhooks:\n  endpoints:\n    - url: https://hooks.example.net/provisioner\n      identities: ['\\.example\\.net$']\n")
+
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Webhooks.Enabled()).To(BeTrue())
+			Expect(cfg.Webhooks.QueueSize).To(Equal(1000))
+			Expect(cfg.Webhooks.Attempts).To(Equal(5))
+			Expect(cfg.Webhooks.TimeoutDuration).To(Equal(10 * time.Second))
+			Expect(cfg.Webhooks.Endpoints[0].Name).To(Equal("hooks.example.net"))
+		})
+	})
+
+	Describe("DiscoverySources", func() {
+		It("Should set source defaults", func() {
+			writeConfig("discovery_sources:\n  - type: broadcast\n  - name: inventory\n    type: http\n    url: https://inventory.example.net/pending\n  - type: registry\n    bucket: REGISTRY\n    max_age: 1h\n")
+
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.DiscoverySources).To(HaveLen(3))
+			Expect(cfg.DiscoverySources[1].Name).To(Equal("inventory"))
+			Expect(cfg.DiscoverySources[1].TimeoutDuration).To(Equal(10 * time.Second))
+			Expect(cfg.DiscoverySources[2].Name).To(Equal("registry"))
+			Expect(cfg.DiscoverySources[2].MaxAgeDuration).To(Equal(time.Hour))
+		})
+	})
+
+	Describe("Backoff", func() {
+		It("Should double the delay up to the maximum", func() {
+			b := Backoff{InitialDuration: 10 * time.Second, MaxDuration: time.Minute}
+
+			Expect(b.Delay(1)).To(Equal(10 * time.Second))
+			Expect(b.Delay(2)).To(Equal(20 * time.Second))
+			Expect(b.Delay(3)).To(Equal(40 * time.Second))
+			Expect(b.Delay(4)).To(Equal(time.Minute))
+			Expect(b.Delay(100)).To(Equal(time.Minute))
+		})
+	})
+
+	Describe("Reload", func() {
+		It("Should require a file", func() {
+			_, _, err := Reload(&Config{})
+			Expect(err).To(MatchError("configuration was not loaded from a file"))
+		})
+
+		It("Should keep the current configuration on error", func() {
+			writeConfig("interval: 1m\n")
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+
+			writeConfig("interval: 10s\n")
+			ncfg, restart, err := Reload(cfg)
+			Expect(err).To(MatchError(ContainSubstring("interval is too small")))
+			Expect(ncfg).To(BeNil())
+			Expect(restart).To(BeNil())
+		})
+
+		It("Should update runtime settings and report static ones", func() {
+			writeConfig("workers: 2\nmonitor_port: 8080\ninterval: 1m\nhelper: /bin/true\ntoken: one\n")
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+
+			writeConfig("workers: 4\nmonitor_port: 9090\ninterval: 5m\nhelper: /bin/false\ntoken: two\nleader_election: true\n")
+			ncfg, restart, err := Reload(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(restart).To(Equal([]string{"workers", "monitor_port", "leader_election"}))
+			Expect(ncfg.Workers).To(Equal(2))
+			Expect(ncfg.MonitorPort).To(Equal(8080))
+			Expect(ncfg.LeaderElection).To(BeFalse())
+			Expect(ncfg.IntervalDuration).To(Equal(5 * time.Minute))
+			Expect(ncfg.Helper).To(Equal("/bin/false"))
+			Expect(ncfg.Token).To(Equal("two"))
+		})
+
+		It("Should share the paused state", func() {
+			writeConfig("interval: 1m\n")
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+			cfg.Pause()
+
+			ncfg, _, err := Reload(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ncfg.Paused()).To(BeTrue())
+
+			ncfg.Resume()
+			Expect(cfg.Paused()).To(BeFalse())
+		})
+	})
+
+	Describe("Validate", func() {
+		It("Should report all problems", func() {
+			writeConfig("interval: 10s\nhelper: /nonexisting/helper\ncert_deny_list: ['(bad']\nfeatures:\n  pki: true\n  ed25519: true\n")
+
+			_, errs := Validate(file)
+			Expect(errs).To(HaveLen(6))
+			Expect(errs[0]).To(MatchError("can only enable one of pki or ed25519 features"))
+			Expect(errs[1]).To(MatchError(ContainSubstring("interval is too small")))
+			Expect(errs[2]).To(MatchError(ContainSubstring("helper /nonexisting/helper is not executable")))
+			Expect(errs[3]).To(MatchError(ContainSubstring("invalid cert_deny_list pattern \"(bad\"")))
+			Expect(errs[4]).To(MatchError("jwt_verify_cert is required when the jwt feature is enabled"))
+			Expect(errs[5]).To(MatchError("jwt_signing_key is required when the ed25519 feature is enabled"))
+		})
+
+		It("Should report unreadable files", func() {
+			_, errs := Validate(filepath.Join(td, "missing.yaml"))
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0]).To(MatchError(ContainSubstring("not found")))
+		})
+
+		Describe("ed25519 settings", func() {
+			var (
+				issuerPub  string
+				signerSeed string
+				signerJWT  string
+			)
+
+			BeforeEach(func() {
+				ipub, ipri, err := choria.Ed25519KeyPair()
+				Expect(err).ToNot(HaveOccurred())
+				issuerPub = hex.EncodeToString(ipub)
+
+				signerSeed = filepath.Join(td, "signer.seed")
+				spub, _, err := choria.Ed25519KeyPairToFile(signerSeed)
+				Expect(err).ToNot(HaveOccurred())
+
+				claims, err := tokens.NewClientIDClaims("signer", nil, "choria", nil, "", "", time.Hour, &tokens.ClientPermissions{ServerProvisioner: true}, spub)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(claims.AddOrgIssuerData(ipri)).To(Succeed())
+
+				signed, err := tokens.SignToken(claims, ipri)
+				Expect(err).ToNot(HaveOccurred())
+
+				signerJWT = filepath.Join(td, "signer.jwt")
+				Expect(os.WriteFile(signerJWT, []byte(signed), 0600)).To(Succeed())
+			})
+
+			It("Should accept a valid chain", func() {
+				writeConfig("helper: /bin/true\njwt_verify_cert: " + issuerPub + "\njwt_signing_key: " + signerSeed + "\njwt_signing_token: " + signerJWT + "\nfeatures:\n  ed25519: true\n")
+
+				_, errs := Validate(file)
+				Expect(errs).To(BeEmpty())
+			})
+
+			It("Should detect a token from another issuer", func() {
+				other, _, err := choria.Ed25519KeyPair()
+				Expect(err).ToNot(HaveOccurred())
+
+				writeConfig("helper: /bin/true\njwt_verify_cert: " + hex.EncodeToString(other) + "\njwt_signing_key: " + signerSeed + "\njwt_signing_token: " + signerJWT + "\nfeatures:\n  ed25519: true\n")
+
+				_, errs := Validate(file)
+				Expect(errs).To(HaveLen(1))
+				Expect(errs[0]).To(MatchError(ContainSubstring("is not signed by the issuer in jwt_verify_cert")))
+			})
+
+			It("Should detect a mismatched signing key", func() {
+				otherSeed := filepath.Join(td, "other.seed")
+				_, _, err := choria.Ed25519KeyPairToFile(otherSeed)
+				Expect(err).ToNot(HaveOccurred())
+
+				writeConfig("helper: /bin/true\njwt_verify_cert: " + issuerPub + "\njwt_signing_key: " + otherSeed + "\njwt_signing_token: " + signerJWT + "\nfeatures:\n  ed25519: true\n")
+
+				_, errs := Validate(file)
+				Expect(errs).To(HaveLen(1))
+				Expect(errs[0]).To(MatchError(ContainSubstring("does not match the public key of jwt_signing_key")))
+			})
+
+			It("Should detect invalid verification keys", func() {
+				writeConfig("helper: /bin/true\njwt_verify_cert: nope\nfeatures:\n  jwt: true\n")
+
+				_, errs := Validate(file)
+				Expect(errs).To(HaveLen(1))
+				Expect(errs[0]).To(MatchError("invalid jwt_verify_cert: not a key file or hex encoded ed25519 public key"))
+			})
+		})
+	})
+
+	Describe("Pausing", func() {
+		It("Should separate standby from operator pauses", func() {
+			cfg := &Config{}
+			cfg.SetStandby(true)
+			Expect(cfg.Paused()).To(BeTrue())
+			Expect(cfg.PauseInfo().Paused).To(BeFalse())
+
+			cfg.Pause()
+			cfg.SetStandby(false)
+			Expect(cfg.Paused()).To(BeTrue())
+
+			cfg.Flip()
+			Expect(cfg.Paused()).To(BeFalse())
+		})
+
+		It("Should persist the pause state", func() {
+			store := NewFilePauseStore(filepath.Join(td, "pause.json"))
+
+			cfg := &Config{}
+			cfg.SetPauseStore(store)
+			Expect(cfg.RestorePauseState()).To(Succeed())
+			Expect(cfg.Paused()).To(BeFalse())
+
+			Expect(cfg.PauseWithReason("ginkgo", "testing")).To(Succeed())
+
+			ncfg := &Config{}
+			ncfg.SetPauseStore(store)
+			Expect(ncfg.RestorePauseState()).To(Succeed())
+			Expect(ncfg.Paused()).To(BeTrue())
+
+			info := ncfg.PauseInfo()
+			Expect(info.By).To(Equal("ginkgo"))
+			Expect(info.Reason).To(Equal("testing"))
+			Expect(info.Since).To(BeTemporally("~", time.Now(), time.Second))
+
+			Expect(ncfg.ResumeWithReason("ginkgo")).To(Succeed())
+			Expect(cfg.RestorePauseState()).To(Succeed())
+			Expect(cfg.Paused()).To(BeFalse())
+		})
+	})
+
+	Describe("Maintenance Windows", func() {
+		at := func(tz string, value string) time.Time {
+			loc, err := time.LoadLocation(tz)
+			Expect(err).ToNot(HaveOccurred())
+			t, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+			Expect(err).ToNot(HaveOccurred())
+			return t
+		}
+
+		It("Should validate windows", func() {
+			writeConfig(`
+maintenance_windows:
+  - cron: "0 2 * * *"
+    duration: 1h
+  - name: both
+    cron: "0 2 * * *"
+    start: "01:00"
+    end: "02:00"
+  - name: cron
+    cron: "0 2 *"
+    duration: 1h
+  - name: days
+    days: [funday]
+    start: "01:00"
+    end: "02:00"
+  - name: tz
+    timezone: Mars/Olympus
+    start: "01:00"
+    end: "02:00"
+  - name: valid
+    start: "01:00"
+    end: "02:00"
+  - name: valid
+    start: "03:00"
+    end: "04:00"
+  - name: typo
+    days: [mondy]
+    start: "01:00"
+    end: "02:00"
+  - name: prefix
+    days: [sunxyz]
+    start: "01:00"
+    end: "02:00"
+`)
+			_, errs := Validate(file)
+			Expect(errs).To(ContainElements(
+				MatchError("invalid maintenance window 1: name is required"),
+				MatchError("invalid maintenance window 2: can only set one of cron or start, end and days"),
+				MatchError(ContainSubstring("invalid maintenance window 3: invalid cron schedule")),
+				MatchError(`invalid maintenance window 4: invalid day "funday"`),
+				MatchError(ContainSubstring(`invalid maintenance window 5: invalid timezone "Mars/Olympus"`)),
+				MatchError(`duplicate maintenance window "valid"`),
+				MatchError(`invalid maintenance window 8: invalid day "mondy"`),
+				MatchError(`invalid maintenance window 9: invalid day "sunxyz"`),
+			))
+		})
+
+		It("Should support cron windows", func() {
+			writeConfig("maintenance_windows:\n  - name: broker\n    cron: \"0 2 * * *\"\n    duration: 90m\n    timezone: Europe/Berlin\n")
+
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+
+			w, _ := cfg.ActiveMaintenanceWindow(at("Europe/Berlin", "2026-10-18 01:59"))
+			Expect(w).To(BeNil())
+
+			w, ends := cfg.ActiveMaintenanceWindow(at("Europe/Berlin", "2026-10-18 02:00"))
+			Expect(w.Name).To(Equal("broker"))
+			Expect(ends).To(BeTemporally("==", at("Europe/Berlin", "2026-10-18 03:30")))
+
+			w, _ = cfg.ActiveMaintenanceWindow(at("UTC", "2026-10-18 01:00"))
+			Expect(w).ToNot(BeNil())
+
+			w, _ = cfg.ActiveMaintenanceWindow(at("Europe/Berlin", "2026-10-18 03:30"))
+			Expect(w).To(BeNil())
+		})
+
+		It("Should use standard cron schedules", func() {
+			writeConfig("maintenance_windows:\n  - name: nightly\n    cron: \"@daily\"\n    duration: 1h\n")
+
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+
+			w, ends := cfg.ActiveMaintenanceWindow(at("UTC", "2026-10-18 00:30"))
+			Expect(w.Name).To(Equal("nightly"))
+			Expect(ends).To(BeTemporally("==", at("UTC", "2026-10-18 01:00")))
+
+			writeConfig("maintenance_windows:\n  - name: seconds\n    cron: \"0 0 2 * * *\"\n    duration: 1h\n")
+			_, err = Load(file)
+			Expect(err).To(MatchError(ContainSubstring("invalid cron schedule")))
+		})
+
+		It("Should support time ranges spanning midnight", func() {
+			writeConfig("maintenance_windows:\n  - name: freeze\n    days: [fri, Saturday]\n    start: \"22:00\"\n    end: \"06:00\"\n")
+
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+
+			// 2026-10-16 is a Friday
+			w, _ := cfg.ActiveMaintenanceWindow(at("UTC", "2026-10-16 21:59"))
+			Expect(w).To(BeNil())
+
+			w, ends := cfg.ActiveMaintenanceWindow(at("UTC", "2026-10-16 22:00"))
+			Expect(w.Name).To(Equal("freeze"))
+			Expect(ends).To(BeTemporally("==", at("UTC", "2026-10-17 06:00")))
+
+			w, ends = cfg.ActiveMaintenanceWindow(at("UTC", "2026-10-18 05:00"))
+			Expect(w.Name).To(Equal("freeze"))
+			Expect(ends).To(BeTemporally("==", at("UTC", "2026-10-18 06:00")))
+
+			w, _ = cfg.ActiveMaintenanceWindow(at("UTC", "2026-10-18 22:00"))
+			Expect(w).To(BeNil())
+		})
+
+		It("Should pause provisioning while active", func() {
+			writeConfig("maintenance_windows:\n  - name: nightly\n    start: \"01:00\"\n    end: \"02:00\"\n")
+
+			cfg, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(cfg.UpdateMaintenanceState(at("UTC", "2026-10-18 01:30"))).To(BeTrue())
+			Expect(cfg.Paused()).To(BeTrue())
+			Expect(cfg.PauseInfo().Paused).To(BeFalse())
+
+			window, ends := cfg.MaintenanceState()
+			Expect(window).To(Equal("nightly"))
+			Expect(ends).To(BeTemporally("==", at("UTC", "2026-10-18 02:00")))
+
+			Expect(cfg.UpdateMaintenanceState(at("UTC", "2026-10-18 01:45"))).To(BeFalse())
+			Expect(cfg.UpdateMaintenanceState(at("UTC", "2026-10-18 02:00"))).To(BeTrue())
+			Expect(cfg.Paused()).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,100 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+)
+
+// Types of discovery source
+const (
+	// DiscoveryBroadcast discovers nodes in the provisioning collective using broadcast discovery
+	DiscoveryBroadcast = "broadcast"
+	// DiscoveryFile reads identities from a file, one per line, or from the names of files in a directory
+	DiscoveryFile = "file"
+	// DiscoveryHTTP fetches a JSON list of identities from a HTTP endpoint
+	DiscoveryHTTP = "http"
+	// DiscoveryRegistry lists identities from the keys of a Choria Key-Value bucket
+	DiscoveryRegistry = "registry"
+)
+
+// DiscoverySource configures a source of nodes to provision, all configured sources are used during discovery
+type DiscoverySource struct {
+	// Name identifies the source in logs and metrics, defaults to the type
+	Name string `json:"name"`
+	// Type is one of broadcast, file, http or registry
+	Type string `json:"type"`
+	// Path is the file or directory to read for file sources
+	Path string `json:"path"`
+	// URL is the address to fetch for http sources
+	URL string `json:"url"`
+	// Headers are added to requests made by http sources
+	Headers map[string]string `json:"headers"`
+	// Query is a GJSON query selecting the list of identities in the http response, the response should be a list when not set
+	Query string `json:"query"`
+	// Timeout is how long http requests can take
+	Timeout string `json:"timeout"`
+	// Bucket is the Key-Value bucket for registry sources
+	Bucket string `json:"bucket"`
+	// MaxAge ignores registry entries that were not updated for this long
+	MaxAge string `json:"max_age"`
+
+	TimeoutDuration time.Duration `json:"-"`
+	MaxAgeDuration  time.Duration `json:"-"`
+}
+
+// prepare sets defaults and validates the source
+func (s *DiscoverySource) prepare() error {
+	var err error
+
+	if s.Name == "" {
+		s.Name = s.Type
+	}
+
+	switch s.Type {
+	case DiscoveryBroadcast:
+
+	case DiscoveryFile:
+		if s.Path == "" {
+			return fmt.Errorf("path is required")
+		}
+
+	case DiscoveryHTTP:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("a http or https url is required")
+		}
+
+		if s.Timeout == "" {
+			s.Timeout = "10s"
+		}
+
+		s.TimeoutDuration, err = choria.ParseDuration(s.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %s", err)
+		}
+
+	case DiscoveryRegistry:
+		if s.Bucket == "" {
+			return fmt.Errorf("bucket is required")
+		}
+
+		if s.MaxAge != "" {
+			s.MaxAgeDuration, err = choria.ParseDuration(s.MaxAge)
+			if err != nil {
+				return fmt.Errorf("invalid max_age: %s", err)
+			}
+		}
+
+	default:
+		return fmt.Errorf("invalid type %q, valid types are %s, %s, %s and %s", s.Type, DiscoveryBroadcast, DiscoveryFile, DiscoveryHTTP, DiscoveryRegistry)
+	}
+
+	return nil
+}
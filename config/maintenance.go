@@ -0,0 +1,197 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// MaintenanceWindow is a period during which provisioning is paused, windows either
+// start on a cron schedule and last for Duration or cover a daily time range on Days
+type MaintenanceWindow struct {
+	Name     string   `json:"name"`
+	Cron     string   `json:"cron"`
+	Duration string   `json:"duration"`
+	Days     []string `json:"days"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Timezone string   `json:"timezone"`
+
+	schedule cron.Schedule
+	duration time.Duration
+	days     map[time.Weekday]bool
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+// weekdays maps full day names and their three letter abbreviations to days
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// prepare parses and validates the window
+func (w *MaintenanceWindow) prepare() error {
+	var err error
+
+	if w.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	w.location = time.UTC
+	if w.Timezone != "" {
+		w.location, err = time.LoadLocation(w.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %s", w.Timezone, err)
+		}
+	}
+
+	switch {
+	case w.Cron != "" && (w.Start != "" || w.End != "" || len(w.Days) > 0):
+		return fmt.Errorf("can only set one of cron or start, end and days")
+
+	case w.Cron != "":
+		w.schedule, err = cron.ParseStandard(w.Cron)
+		if err != nil {
+			return fmt.Errorf("invalid cron schedule %q: %s", w.Cron, err)
+		}
+
+		w.duration, err = time.ParseDuration(w.Duration)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %s", w.Duration, err)
+		}
+		if w.duration <= 0 {
+			return fmt.Errorf("duration must be positive")
+		}
+
+	case w.Start != "" && w.End != "":
+		w.start, err = parseTimeOfDay(w.Start)
+		if err != nil {
+			return fmt.Errorf("invalid start: %s", err)
+		}
+
+		w.end, err = parseTimeOfDay(w.End)
+		if err != nil {
+			return fmt.Errorf("invalid end: %s", err)
+		}
+
+		if w.start == w.end {
+			return fmt.Errorf("start and end can not be the same")
+		}
+
+		w.days = make(map[time.Weekday]bool)
+		for _, d := range w.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return fmt.Errorf("invalid day %q", d)
+			}
+			w.days[day] = true
+		}
+
+	default:
+		return fmt.Errorf("either cron and duration or start and end are required")
+	}
+
+	return nil
+}
+
+// activeAt determines if the window covers t and when it ends
+func (w *MaintenanceWindow) activeAt(t time.Time) (bool, time.Time) {
+	t = t.In(w.location)
+
+	if w.schedule != nil {
+		start := w.schedule.Next(t.Add(-w.duration))
+		if start.After(t) {
+			return false, time.Time{}
+		}
+
+		return true, start.Add(w.duration)
+	}
+
+	// a range that ends before it starts runs past midnight so may have started the day before
+	for _, offset := range []int{0, -1} {
+		day := t.AddDate(0, 0, offset)
+		midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, w.location)
+
+		if len(w.days) > 0 && !w.days[midnight.Weekday()] {
+			continue
+		}
+
+		start := midnight.Add(w.start)
+		end := midnight.Add(w.end)
+		if w.end < w.start {
+			end = end.AddDate(0, 0, 1)
+		}
+
+		if !t.Before(start) && t.Before(end) {
+			return true, end
+		}
+	}
+
+	return false, time.Time{}
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ActiveMaintenanceWindow is the maintenance window covering t and when it ends, nil when none are active
+func (c *Config) ActiveMaintenanceWindow(t time.Time) (*MaintenanceWindow, time.Time) {
+	for i := range c.MaintenanceWindows {
+		w := &c.MaintenanceWindows[i]
+		if w.location == nil {
+			continue
+		}
+
+		active, ends := w.activeAt(t)
+		if active {
+			return w, ends
+		}
+	}
+
+	return nil, time.Time{}
+}
+
+// UpdateMaintenanceState records the active maintenance window in the pause state, returns true when a window started or ended
+func (c *Config) UpdateMaintenanceState(t time.Time) bool {
+	window, ends := c.ActiveMaintenanceWindow(t)
+
+	name := ""
+	if window != nil {
+		name = window.Name
+	}
+
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	changed := ps.maintenance != name
+	ps.maintenance = name
+	ps.maintenanceEnds = ends
+	c.setPauseStat(ps)
+
+	return changed
+}
+
+// MaintenanceState is the name of the active maintenance window and when it ends, empty when none are active
+func (c *Config) MaintenanceState() (string, time.Time) {
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	return ps.maintenance, ps.maintenanceEnds
+}
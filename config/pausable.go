@@ -4,45 +4,180 @@
 
 package config
 
-// Pause implements backplane.Pausable
-func (c *Config) Pause() {
-	c.Lock()
-	defer c.Unlock()
+import (
+	"sync"
+	"time"
+)
 
-	c.paused = true
-	c.setPauseStat()
+// PauseInfo describes why provisioning was paused by an operator
+type PauseInfo struct {
+	Paused bool      `json:"paused"`
+	By     string    `json:"by,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
 }
 
-// Resume implements backplane.Pausable
-func (c *Config) Resume() {
-	c.Lock()
-	defer c.Unlock()
+// PauseStore persists the paused state so it survives restarts
+type PauseStore interface {
+	Load() (*PauseInfo, error)
+	Save(*PauseInfo) error
+}
 
-	c.paused = false
-	c.setPauseStat()
+// pauseState is shared between a configuration and any configuration
+// that replaces it during a reload so that pausing is not lost
+type pauseState struct {
+	info            PauseInfo
+	standby         bool
+	maintenance     string
+	maintenanceEnds time.Time
+	store           PauseStore
+	sync.Mutex
 }
 
-// Flip implements backplane.Pausable
-func (c *Config) Flip() {
-	c.Lock()
-	defer c.Unlock()
+// Pause pauses provisioning without recording who paused it, failures to persist the state are ignored
+func (c *Config) Pause() {
+	c.PauseWithReason("", "")
+}
 
-	c.paused = !c.paused
-	c.setPauseStat()
+// Resume resumes provisioning, failures to persist the state are ignored
+func (c *Config) Resume() {
+	c.ResumeWithReason("")
 }
 
-// Paused implements backplane.Pausable
+// Flip pauses or resumes provisioning based on the current state, failures to persist the state are ignored
+func (c *Config) Flip() {
+	if c.PauseInfo().Paused {
+		c.Resume()
+	} else {
+		c.Pause()
+	}
+}
+
+// Paused is true when paused by an operator, during a maintenance window or while standing by during leader election
 func (c *Config) Paused() bool {
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	return ps.paused()
+}
+
+// PauseWithReason pauses provisioning recording who paused it and why, the state is persisted when a store is set
+func (c *Config) PauseWithReason(by string, reason string) error {
+	return c.setPauseInfo(PauseInfo{Paused: true, By: by, Reason: reason, Since: time.Now().UTC()})
+}
+
+// ResumeWithReason resumes provisioning recording who resumed it, the state is persisted when a store is set
+func (c *Config) ResumeWithReason(by string) error {
+	return c.setPauseInfo(PauseInfo{Paused: false, By: by, Since: time.Now().UTC()})
+}
+
+// PauseInfo is the current operator pause state
+func (c *Config) PauseInfo() PauseInfo {
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	return ps.info
+}
+
+// SetStandby pauses or resumes provisioning while standing by for leader election without changing the operator pause state
+func (c *Config) SetStandby(standby bool) {
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	ps.standby = standby
+	c.setPauseStat(ps)
+}
+
+// SetPauseStore sets the store used to persist the pause state
+func (c *Config) SetPauseStore(store PauseStore) {
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	ps.store = store
+}
+
+// RestorePauseState loads the pause state from the store, does nothing when no store is set
+func (c *Config) RestorePauseState() error {
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	if ps.store == nil {
+		return nil
+	}
+
+	info, err := ps.store.Load()
+	if err != nil {
+		return err
+	}
+
+	if info != nil {
+		ps.info = *info
+	}
+
+	c.setPauseStat(ps)
+
+	return nil
+}
+
+// ApplyPauseInfo sets the pause state without persisting it, used when another instance changed the stored state
+func (c *Config) ApplyPauseInfo(info PauseInfo) {
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	ps.info = info
+	c.setPauseStat(ps)
+}
+
+func (c *Config) setPauseInfo(info PauseInfo) error {
+	ps := c.pauseState()
+	ps.Lock()
+	defer ps.Unlock()
+
+	ps.info = info
+	c.setPauseStat(ps)
+
+	if ps.store == nil {
+		return nil
+	}
+
+	return ps.store.Save(&info)
+}
+
+func (c *Config) pauseState() *pauseState {
 	c.Lock()
 	defer c.Unlock()
 
-	return c.paused
+	if c.pause == nil {
+		c.pause = &pauseState{}
+	}
+
+	return c.pause
+}
+
+func (ps *pauseState) paused() bool {
+	return ps.info.Paused || ps.standby || ps.maintenance != ""
 }
 
-func (c *Config) setPauseStat() {
-	if c.paused {
+func (c *Config) setPauseStat(ps *pauseState) {
+	if ps.paused() {
 		pausedGauge.WithLabelValues(c.Site).Set(1)
 	} else {
 		pausedGauge.WithLabelValues(c.Site).Set(0)
 	}
+
+	pauseInfoGauge.DeletePartialMatch(map[string]string{"site": c.Site})
+	if ps.info.Paused {
+		pauseInfoGauge.WithLabelValues(c.Site, ps.info.By, ps.info.Reason, ps.info.Since.Format(time.RFC3339)).Set(1)
+	}
+
+	maintenanceGauge.DeletePartialMatch(map[string]string{"site": c.Site})
+	if ps.maintenance != "" {
+		maintenanceGauge.WithLabelValues(c.Site, ps.maintenance).Set(float64(ps.maintenanceEnds.Unix()))
+	}
 }
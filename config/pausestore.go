@@ -0,0 +1,68 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FilePauseStore persists the pause state in a JSON file
+type FilePauseStore struct {
+	file string
+}
+
+// NewFilePauseStore creates a store that persists the pause state in file
+func NewFilePauseStore(file string) *FilePauseStore {
+	return &FilePauseStore{file: file}
+}
+
+// Load reads the pause state, a missing file is treated as not paused
+func (s *FilePauseStore) Load() (*PauseInfo, error) {
+	j, err := os.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return &PauseInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read pause state %s: %s", s.file, err)
+	}
+
+	info := &PauseInfo{}
+	err = json.Unmarshal(j, info)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse pause state %s: %s", s.file, err)
+	}
+
+	return info, nil
+}
+
+// Save writes the pause state, the file is replaced atomically
+func (s *FilePauseStore) Save(info *PauseInfo) error {
+	j, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	tf, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+	if err != nil {
+		return fmt.Errorf("could not save pause state: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	_, err = tf.Write(j)
+	tf.Close()
+	if err != nil {
+		return fmt.Errorf("could not save pause state: %s", err)
+	}
+
+	err = os.Rename(tf.Name(), s.file)
+	if err != nil {
+		return fmt.Errorf("could not save pause state: %s", err)
+	}
+
+	return nil
+}
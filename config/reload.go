@@ -0,0 +1,78 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// Reload reads the file current was loaded from again and validates it using the same rules as Load.
+//
+// Settings that can only be changed by restarting the provisioner keep their current values in the
+// returned configuration and the names of any that were changed in the file are returned so they can
+// be reported. The paused state is shared with current so pausing is not lost during a reload.
+func Reload(current *Config) (*Config, []string, error) {
+	if current.File == "" {
+		return nil, nil, fmt.Errorf("configuration was not loaded from a file")
+	}
+
+	cfg, err := Load(current.File)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restart := current.keepStaticSettings(cfg)
+
+	cfg.pause = current.pauseState()
+	cfg.pause.Lock()
+	cfg.setPauseStat(cfg.pause)
+	cfg.pause.Unlock()
+
+	return cfg, restart, nil
+}
+
+// keepStaticSettings copies settings that cannot change at runtime from c into n, returns the names of those that differed
+func (c *Config) keepStaticSettings(n *Config) []string {
+	var restart []string
+
+	keep := func(name string, changed bool, reset func()) {
+		if changed {
+			restart = append(restart, name)
+			reset()
+		}
+	}
+
+	keep("workers", c.Workers != n.Workers, func() { n.Workers = c.Workers })
+	keep("logfile", c.Logfile != n.Logfile, func() { n.Logfile = c.Logfile })
+	keep("loglevel", c.Loglevel != n.Loglevel, func() { n.Loglevel = c.Loglevel })
+	keep("lifecycle_components", !slices.Equal(c.LifecycleComponents, n.LifecycleComponents), func() {
+		n.LifecycleComponent = c.LifecycleComponent
+		n.LifecycleComponents = c.LifecycleComponents
+	})
+	keep("collective", c.Collective != n.Collective, func() { n.Collective = c.Collective })
+	keep("choria_insecure", c.Insecure != n.Insecure, func() { n.Insecure = c.Insecure })
+	keep("site", c.Site != n.Site, func() { n.Site = c.Site })
+	keep("monitor_port", c.MonitorPort != n.MonitorPort, func() { n.MonitorPort = c.MonitorPort })
+	keep("management_agent", c.ManagementAgent != n.ManagementAgent, func() { n.ManagementAgent = c.ManagementAgent })
+	keep("broker_port", c.BrokerPort != n.BrokerPort, func() { n.BrokerPort = c.BrokerPort })
+	keep("broker_provisioning_password", c.BrokerProvisionPassword != n.BrokerProvisionPassword, func() { n.BrokerProvisionPassword = c.BrokerProvisionPassword })
+	keep("leader_election", c.LeaderElection != n.LeaderElection, func() { n.LeaderElection = c.LeaderElection })
+	keep("pause_state_file", c.PauseStateFile != n.PauseStateFile, func() { n.PauseStateFile = c.PauseStateFile })
+	keep("pause_state_bucket", c.PauseStateBucket != n.PauseStateBucket, func() { n.PauseStateBucket = c.PauseStateBucket })
+	keep("queue_directory", c.QueueDirectory != n.QueueDirectory, func() { n.QueueDirectory = c.QueueDirectory })
+	keep("queue_bucket", c.QueueBucket != n.QueueBucket, func() { n.QueueBucket = c.QueueBucket })
+	keep("quarantine_bucket", c.QuarantineBucket != n.QuarantineBucket, func() { n.QuarantineBucket = c.QuarantineBucket })
+	keep("sharding", c.Sharding != n.Sharding, func() { n.Sharding = c.Sharding })
+	keep("locks", c.Locks != n.Locks, func() { n.Locks = c.Locks })
+	keep("dry_run", c.DryRun != n.DryRun, func() { n.DryRun = c.DryRun })
+	keep("webhooks", !reflect.DeepEqual(c.Webhooks, n.Webhooks), func() { n.Webhooks = c.Webhooks })
+	keep("tracing", !reflect.DeepEqual(c.Tracing, n.Tracing), func() { n.Tracing = c.Tracing })
+	keep("audit_log", c.AuditLog != n.AuditLog, func() { n.AuditLog = c.AuditLog })
+	keep("discovery_sources", !reflect.DeepEqual(c.DiscoverySources, n.DiscoverySources), func() { n.DiscoverySources = c.DiscoverySources })
+
+	return restart
+}
@@ -11,8 +11,20 @@ var (
 		Name: "choria_provisioner_paused",
 		Help: "Indicates if the provisioner is paused",
 	}, []string{"site"})
+
+	pauseInfoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "choria_provisioner_pause_info",
+		Help: "Information about why the provisioner was paused by an operator",
+	}, []string{"site", "by", "reason", "since"})
+
+	maintenanceGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "choria_provisioner_maintenance_window",
+		Help: "The Unix time when the active maintenance window ends",
+	}, []string{"site", "window"})
 )
 
 func init() {
 	prometheus.MustRegister(pausedGauge)
+	prometheus.MustRegister(pauseInfoGauge)
+	prometheus.MustRegister(maintenanceGauge)
 }
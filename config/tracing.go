@@ -0,0 +1,68 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Exporters that can receive traces
+const (
+	// TracingOTLP sends traces to an OpenTelemetry collector using OTLP over HTTP
+	TracingOTLP = "otlp"
+	// TracingFile writes traces to a file as JSON
+	TracingFile = "file"
+	// TracingStdout writes traces to STDOUT as JSON
+	TracingStdout = "stdout"
+)
+
+// Tracing exports OpenTelemetry traces of provisioning nodes
+type Tracing struct {
+	// Exporter is one of otlp, file or stdout, tracing is disabled when not set
+	Exporter string `json:"exporter"`
+	// Endpoint is the URL of the OTLP HTTP receiver, the standard OTEL_EXPORTER_OTLP environment variables are used when not set
+	Endpoint string `json:"endpoint"`
+	// Headers are sent with every OTLP request
+	Headers map[string]string `json:"headers"`
+	// File is where the file exporter writes traces
+	File string `json:"file"`
+	// ServiceName identifies the provisioner in traces
+	ServiceName string `json:"service_name"`
+}
+
+// Enabled determines if traces are exported
+func (t Tracing) Enabled() bool {
+	return t.Exporter != ""
+}
+
+// prepare sets defaults and validates the tracing settings
+func (t *Tracing) prepare() error {
+	if t.ServiceName == "" {
+		t.ServiceName = "choria-provisioner"
+	}
+
+	switch t.Exporter {
+	case "", TracingStdout:
+
+	case TracingOTLP:
+		if t.Endpoint != "" {
+			u, err := url.Parse(t.Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("tracing.endpoint should be a http or https url")
+			}
+		}
+
+	case TracingFile:
+		if t.File == "" {
+			return fmt.Errorf("tracing.file is required for the file exporter")
+		}
+
+	default:
+		return fmt.Errorf("invalid tracing.exporter %q, valid exporters are %s, %s and %s", t.Exporter, TracingOTLP, TracingFile, TracingStdout)
+	}
+
+	return nil
+}
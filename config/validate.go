@@ -0,0 +1,228 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/tokens"
+	"github.com/kballard/go-shellquote"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// Validate reads file and performs all the checks Load does along with checks of the helper,
+// keys, tokens and patterns it references. Unlike Load every problem found is returned.
+func Validate(file string) (*Config, []error) {
+	cfg, err := parse(file)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	errs := cfg.validate()
+	errs = append(errs, cfg.validateHelper()...)
+	errs = append(errs, cfg.validateCertDenyList()...)
+	errs = append(errs, cfg.validateJWTVerifyCert()...)
+	errs = append(errs, cfg.validateSigningKeyAndToken()...)
+	errs = append(errs, cfg.validateUpgrades()...)
+	errs = append(errs, cfg.validateRegoPolicy()...)
+
+	return cfg, errs
+}
+
+func (c *Config) validateHelper() []error {
+	if c.Helper == "" {
+		return []error{fmt.Errorf("helper is not set")}
+	}
+
+	parts, err := shellquote.Split(c.Helper)
+	if err != nil {
+		return []error{fmt.Errorf("cannot parse helper command: %s", err)}
+	}
+
+	if len(parts) == 0 {
+		return []error{fmt.Errorf("cannot parse helper command: empty")}
+	}
+
+	_, err = exec.LookPath(parts[0])
+	if err != nil {
+		return []error{fmt.Errorf("helper %s is not executable: %s", parts[0], err)}
+	}
+
+	return nil
+}
+
+func (c *Config) validateCertDenyList() []error {
+	var errs []error
+
+	for _, pattern := range c.CertDenyList {
+		if strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") && len(pattern) > 2 {
+			pattern = strings.TrimPrefix(strings.TrimSuffix(pattern, "/"), "/")
+		}
+
+		_, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid cert_deny_list pattern %q: %s", pattern, err))
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateJWTVerifyCert() []error {
+	if !c.Features.JWT {
+		return nil
+	}
+
+	if c.JWTVerifyCert == "" {
+		return []error{fmt.Errorf("jwt_verify_cert is required when the jwt feature is enabled")}
+	}
+
+	_, err := c.jwtVerifyKey()
+	if err != nil {
+		return []error{fmt.Errorf("invalid jwt_verify_cert: %s", err)}
+	}
+
+	return nil
+}
+
+// jwtVerifyKey parses jwt_verify_cert either as a file holding a RSA or ed25519 public key or as a hex encoded ed25519 public key
+func (c *Config) jwtVerifyKey() (any, error) {
+	dat := []byte(c.JWTVerifyCert)
+
+	if _, err := os.Stat(c.JWTVerifyCert); err == nil {
+		dat, err = os.ReadFile(c.JWTVerifyCert)
+		if err != nil {
+			return nil, err
+		}
+
+		if block, _ := pem.Decode(dat); block != nil {
+			pk, err := publicKeyFromPEM(block)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse %s: %s", c.JWTVerifyCert, err)
+			}
+
+			return pk, nil
+		}
+	}
+
+	pk, err := hex.DecodeString(strings.TrimSpace(string(dat)))
+	if err != nil {
+		return nil, fmt.Errorf("not a key file or hex encoded ed25519 public key")
+	}
+
+	if len(pk) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key size")
+	}
+
+	return ed25519.PublicKey(pk), nil
+}
+
+func publicKeyFromPEM(block *pem.Block) (any, error) {
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return cert.PublicKey, nil
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func (c *Config) validateSigningKeyAndToken() []error {
+	if !c.Features.ED25519 {
+		return nil
+	}
+
+	if c.JWTSigningKey == "" {
+		return []error{fmt.Errorf("jwt_signing_key is required when the ed25519 feature is enabled")}
+	}
+
+	pubK, _, err := choria.Ed25519KeyPairFromSeedFile(c.JWTSigningKey)
+	if err != nil {
+		return []error{fmt.Errorf("invalid jwt_signing_key: %s", err)}
+	}
+
+	if c.JWTSigningToken == "" {
+		return nil
+	}
+
+	t, err := os.ReadFile(c.JWTSigningToken)
+	if err != nil {
+		return []error{fmt.Errorf("invalid jwt_signing_token: %s", err)}
+	}
+
+	token, err := tokens.ParseClientIDTokenUnverified(strings.TrimSpace(string(t)))
+	if err != nil {
+		return []error{fmt.Errorf("invalid jwt_signing_token: %s", err)}
+	}
+
+	var errs []error
+
+	if token.IsExpired() {
+		errs = append(errs, fmt.Errorf("jwt_signing_token %s has expired", c.JWTSigningToken))
+	}
+
+	if token.PublicKey != hex.EncodeToString(pubK) {
+		errs = append(errs, fmt.Errorf("jwt_signing_token %s does not match the public key of jwt_signing_key %s", c.JWTSigningToken, c.JWTSigningKey))
+	}
+
+	if token.Permissions == nil || !token.Permissions.ServerProvisioner {
+		errs = append(errs, fmt.Errorf("jwt_signing_token %s does not have the server provisioner permission", c.JWTSigningToken))
+	}
+
+	if token.TrustChainSignature == "" {
+		errs = append(errs, fmt.Errorf("no Trust Chain Signature claim in JWT Signing Token"))
+	} else if !token.IsChainedIssuer(true) {
+		errs = append(errs, fmt.Errorf("jwt_signing_token %s is not a valid chained issuer", c.JWTSigningToken))
+	} else if c.JWTVerifyCert != "" {
+		issuer, err := c.jwtVerifyKey()
+		if edIssuer, ok := issuer.(ed25519.PublicKey); err == nil && ok {
+			signed, _, err := token.IsSignedByIssuer(edIssuer)
+			if err != nil || !signed {
+				errs = append(errs, fmt.Errorf("jwt_signing_token %s is not signed by the issuer in jwt_verify_cert", c.JWTSigningToken))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (c *Config) validateUpgrades() []error {
+	if c.Features.VersionUpgrades && c.UpgradesRepo == "" && !c.UpgradesOptional {
+		return []error{fmt.Errorf("upgrades_repository is required when the upgrades feature is enabled")}
+	}
+
+	return nil
+}
+
+func (c *Config) validateRegoPolicy() []error {
+	if c.RegoPolicy == "" {
+		return nil
+	}
+
+	policy, err := os.ReadFile(c.RegoPolicy)
+	if err != nil {
+		return []error{fmt.Errorf("invalid rego_policy: %s", err)}
+	}
+
+	_, err = rego.New(rego.Query("data.io.choria.provisioner"), rego.Module(c.RegoPolicy, string(policy))).PrepareForEval(context.Background())
+	if err != nil {
+		return []error{fmt.Errorf("invalid rego_policy: %s", err)}
+	}
+
+	return nil
+}
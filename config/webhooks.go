@@ -0,0 +1,140 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+)
+
+// Outcomes that can be posted to webhooks
+const (
+	// WebhookProvisioned is posted when a node was provisioned
+	WebhookProvisioned = "provisioned"
+	// WebhookFailed is posted when provisioning a node failed
+	WebhookFailed = "failed"
+	// WebhookShutdown is posted when a node was shut down
+	WebhookShutdown = "shutdown"
+	// WebhookQuarantined is posted when a node was quarantined after failing too often
+	WebhookQuarantined = "quarantined"
+)
+
+// Webhooks posts the outcome of provisioning nodes to HTTP endpoints
+type Webhooks struct {
+	// Endpoints are the URLs to post to
+	Endpoints []WebhookEndpoint `json:"endpoints"`
+	// QueueSize is how many deliveries can wait to be sent to each endpoint, deliveries are dropped when the queue is full
+	QueueSize int `json:"queue_size"`
+	// Attempts is how many times a delivery is tried before it is dropped
+	Attempts int `json:"attempts"`
+	// Timeout is how long each delivery attempt can take
+	Timeout string `json:"timeout"`
+
+	TimeoutDuration time.Duration `json:"-"`
+}
+
+// WebhookEndpoint is a URL that receives the outcomes it is interested in
+type WebhookEndpoint struct {
+	// Name identifies the endpoint in logs and metrics, defaults to the URL host
+	Name string `json:"name"`
+	// URL is the address to post to
+	URL string `json:"url"`
+	// Secret signs the payload using HMAC-SHA256, the signature is sent in the X-Choria-Signature header
+	Secret string `json:"secret"`
+	// Outcomes limits the outcomes posted to this endpoint, all are posted when empty
+	Outcomes []string `json:"outcomes"`
+	// Identities limits the nodes posted to this endpoint to those matching any of these regular expressions
+	Identities []string `json:"identities"`
+}
+
+// Enabled determines if any webhooks are configured
+func (w Webhooks) Enabled() bool {
+	return len(w.Endpoints) > 0
+}
+
+// prepare sets defaults and validates the webhooks
+func (w *Webhooks) prepare() []error {
+	var errs []error
+
+	if w.QueueSize == 0 {
+		w.QueueSize = 1000
+	}
+
+	if w.Attempts == 0 {
+		w.Attempts = 5
+	}
+
+	if w.Timeout == "" {
+		w.Timeout = "10s"
+	}
+
+	if w.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("webhooks.queue_size should be at least 1"))
+	}
+
+	if w.Attempts < 1 {
+		errs = append(errs, fmt.Errorf("webhooks.attempts should be at least 1"))
+	}
+
+	var err error
+	w.TimeoutDuration, err = choria.ParseDuration(w.Timeout)
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("invalid webhooks.timeout duration: %s", err))
+	case w.TimeoutDuration < time.Second:
+		errs = append(errs, fmt.Errorf("webhooks.timeout should be at least 1s"))
+	}
+
+	names := make(map[string]bool)
+	for i := range w.Endpoints {
+		e := &w.Endpoints[i]
+
+		err := e.prepare()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid webhook endpoint %d: %s", i+1, err))
+			continue
+		}
+
+		if names[e.Name] {
+			errs = append(errs, fmt.Errorf("duplicate webhook endpoint %q", e.Name))
+		}
+		names[e.Name] = true
+	}
+
+	return errs
+}
+
+// prepare sets defaults and validates the endpoint
+func (e *WebhookEndpoint) prepare() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("a http or https url is required")
+	}
+
+	if e.Name == "" {
+		e.Name = u.Host
+	}
+
+	valid := []string{WebhookProvisioned, WebhookFailed, WebhookShutdown, WebhookQuarantined}
+	for _, outcome := range e.Outcomes {
+		if !slices.Contains(valid, outcome) {
+			return fmt.Errorf("invalid outcome %q, valid outcomes are %s, %s, %s and %s", outcome, WebhookProvisioned, WebhookFailed, WebhookShutdown, WebhookQuarantined)
+		}
+	}
+
+	for _, pattern := range e.Identities {
+		_, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid identities pattern %q: %s", pattern, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package host
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"slices"
+	"time"
+)
+
+// Decisions the helper can make about a node
+const (
+	// DecisionConfigure means the helper supplied configuration for the node
+	DecisionConfigure = "configure"
+	// DecisionDefer means the helper deferred provisioning the node
+	DecisionDefer = "defer"
+	// DecisionShutdown means the helper asked for the node to be shut down
+	DecisionShutdown = "shutdown"
+)
+
+// AuditStep is a step taken while provisioning a node
+type AuditStep struct {
+	Name string `json:"name"`
+	// Duration is how long the step took in seconds
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Audit describes what was done during the most recent provisioning attempt and which credentials were issued
+type Audit struct {
+	Steps             []AuditStep `json:"steps"`
+	PolicyDecision    string      `json:"policy_decision,omitempty"`
+	HelperDecision    string      `json:"helper_decision,omitempty"`
+	HelperMessage     string      `json:"helper_message,omitempty"`
+	CertificateSerial string      `json:"certificate_serial,omitempty"`
+	ServerJWTID       string      `json:"server_jwt_id,omitempty"`
+	UpgradeVersion    string      `json:"upgrade_version,omitempty"`
+}
+
+// Audit is a record of the most recent provisioning attempt
+func (h *Host) Audit() Audit {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	audit := h.audit
+	audit.Steps = slices.Clone(h.audit.Steps)
+
+	return audit
+}
+
+// step records a step that started at started and returns err, arguments are evaluated
+// left to right so h.step("x", time.Now(), h.x()) times the call to x
+func (h *Host) step(name string, started time.Time, err error) error {
+	s := AuditStep{Name: name, Duration: time.Since(started).Seconds()}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	h.audit.Steps = append(h.audit.Steps, s)
+
+	return err
+}
+
+// certificateSerial is the hex serial of the first certificate in the PEM data c
+func certificateSerial(c string) string {
+	block, _ := pem.Decode([]byte(c))
+	if block == nil {
+		return ""
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return ""
+	}
+
+	return cert.SerialNumber.Text(16)
+}
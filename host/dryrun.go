@@ -0,0 +1,150 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package host
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Actions a dry run plan can describe
+const (
+	// PlanConfigure is a plan to configure and restart a node
+	PlanConfigure = "configure"
+	// PlanShutdown is a plan to shut a node down
+	PlanShutdown = "shutdown"
+)
+
+// Plan is what would have been sent to a node had provisioning not been a dry run
+type Plan struct {
+	Identity       string            `json:"identity"`
+	Time           time.Time         `json:"time"`
+	Action         string            `json:"action"`
+	Reason         string            `json:"reason,omitempty"`
+	UpgradeVersion string            `json:"upgrade_version,omitempty"`
+	Configuration  map[string]string `json:"configuration,omitempty"`
+	CA             string            `json:"ca,omitempty"`
+	Certificate    string            `json:"certificate,omitempty"`
+	SSLDir         string            `json:"ssldir,omitempty"`
+	KeySet         bool              `json:"key_set,omitempty"`
+	ServerJWT      string            `json:"server_jwt,omitempty"`
+	ActionPolicies map[string]any    `json:"action_policies,omitempty"`
+	OPAPolicies    map[string]any    `json:"opa_policies,omitempty"`
+}
+
+// Planned is true when a dry run plan was produced instead of provisioning the node
+func (h *Host) Planned() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.plan != nil
+}
+
+// configurePlan describes the configure, restart and upgrade requests that would be sent
+func (h *Host) configurePlan() *Plan {
+	plan := &Plan{
+		Identity:       h.Identity,
+		Time:           time.Now().UTC(),
+		Action:         PlanConfigure,
+		Configuration:  h.config,
+		CA:             h.ca,
+		Certificate:    h.cert,
+		SSLDir:         h.sslDir,
+		KeySet:         h.key != "",
+		ServerJWT:      h.signedServerJWT,
+		ActionPolicies: h.actionPolicies,
+		OPAPolicies:    h.opaPolicies,
+	}
+
+	if h.CSR != nil && h.CSR.SSLDir != "" {
+		plan.SSLDir = h.CSR.SSLDir
+	}
+
+	if h.cfg.Features.VersionUpgrades && h.upgradeTargetVersion != "" {
+		current := NewVersion(h.version)
+		if !current.Equal(NewVersion(h.upgradeTargetVersion)) {
+			plan.UpgradeVersion = h.upgradeTargetVersion
+		}
+	}
+
+	return plan
+}
+
+// redactedValue replaces secrets in plans that are logged
+const redactedValue = "[redacted]"
+
+// redacted is a copy of the plan with the server JWT and configuration values replaced so it can be logged
+func (p *Plan) redacted() *Plan {
+	plan := *p
+
+	if plan.ServerJWT != "" {
+		plan.ServerJWT = redactedValue
+	}
+
+	if len(plan.Configuration) > 0 {
+		plan.Configuration = make(map[string]string, len(p.Configuration))
+		for k := range p.Configuration {
+			plan.Configuration[k] = redactedValue
+		}
+	}
+
+	return &plan
+}
+
+// recordPlan writes plan to the dry run directory or logs it, without secrets, when no directory is configured
+func (h *Host) recordPlan(plan *Plan) error {
+	h.plan = plan
+
+	if h.cfg.DryRunDirectory == "" {
+		j, err := json.MarshalIndent(plan.redacted(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("could not encode dry run plan: %s", err)
+		}
+
+		h.log.Infof("Dry run plan: %s", j)
+		return nil
+	}
+
+	j, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode dry run plan: %s", err)
+	}
+
+	err = os.MkdirAll(h.cfg.DryRunDirectory, 0700)
+	if err != nil {
+		return fmt.Errorf("could not create dry run directory: %s", err)
+	}
+
+	if filepath.Base(h.Identity) != h.Identity || strings.HasPrefix(h.Identity, ".") {
+		return fmt.Errorf("can not write a dry run plan for identity %q", h.Identity)
+	}
+
+	file := filepath.Join(h.cfg.DryRunDirectory, h.Identity+".json")
+
+	tf, err := os.CreateTemp(h.cfg.DryRunDirectory, ".plan-*")
+	if err != nil {
+		return fmt.Errorf("could not write dry run plan: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	_, err = tf.Write(j)
+	tf.Close()
+	if err != nil {
+		return fmt.Errorf("could not write dry run plan: %s", err)
+	}
+
+	err = os.Rename(tf.Name(), file)
+	if err != nil {
+		return fmt.Errorf("could not write dry run plan: %s", err)
+	}
+
+	h.log.Infof("Wrote dry run plan to %s", file)
+
+	return nil
+}
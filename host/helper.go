@@ -11,13 +11,13 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
-	"time"
 
 	"github.com/choria-io/provisioner/config"
 	"github.com/choria-io/tokens"
 	"github.com/kballard/go-shellquote"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ConfigResponse struct {
@@ -43,7 +43,9 @@ func (h *Host) getConfig(ctx context.Context) (*ConfigResponse, error) {
 		return nil, fmt.Errorf("could not JSON encode host: %s", err)
 	}
 
+	ctx, span := tracer.Start(ctx, "helper", trace.WithAttributes(h.spanAttributes()...))
 	err = runDecodedHelper(ctx, string(input), r, h.cfg, h.log)
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("could not invoke configure helper: %s", err)
 	}
@@ -73,7 +75,7 @@ func runHelper(ctx context.Context, input string, cfg *config.Config) ([]byte, e
 		return nil, fmt.Errorf("provisioning is paused, cannot perform %s", cfg.Helper)
 	}
 
-	tctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	tctx, cancel := context.WithTimeout(ctx, cfg.Timeouts.HelperDuration)
 	defer cancel()
 
 	parts, err := shellquote.Split(cfg.Helper)
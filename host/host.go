@@ -11,7 +11,9 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
@@ -24,14 +26,22 @@ import (
 	"github.com/choria-io/provisioner/config"
 	"github.com/choria-io/tokens"
 	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrDeferred indicates that the helper or admission policy deferred provisioning until the node is found again
+var ErrDeferred = errors.New("provisioning deferred")
+
 type Host struct {
 	Identity             string                     `json:"identity"`
 	CSR                  *provision.CSRReply        `json:"csr"`
 	ED25519PubKey        *provision.ED25519Reply    `json:"ed25519_pubkey"`
 	Metadata             string                     `json:"inventory"`
 	JWT                  *tokens.ProvisioningClaims `json:"jwt"`
+	Attempt              int                        `json:"attempt"`
+	LastError            string                     `json:"last_error"`
 	rawJWT               string
 	config               map[string]string
 	provisioned          bool
@@ -49,104 +59,379 @@ type Host struct {
 	version              string
 	upgradable           bool
 	upgradeTargetVersion string
+	shutdownRequested    bool
+	eventSourced         bool
+	vetted               bool
+	priority             int
+	priorityKnown        bool
+	plan                 *Plan
+	audit                Audit
+	notifier             Notifier
+	state                stateMachine
 
 	discovered time.Time
-	cfg        *config.Config
-	token      string
-	fw         *choria.Framework
-	log        *logrus.Entry
-	mu         *sync.Mutex
-	replylock  *sync.Mutex
+	queued     time.Time
+	// now supplies the current time used to determine if the host waited too long, it should be the
+	// same clock that set the discovered and queued times
+	now       func() time.Time
+	cfg       *config.Config
+	token     string
+	fw        *choria.Framework
+	log       *logrus.Entry
+	mu        *sync.Mutex
+	replylock *sync.Mutex
+	// imu protects the attempt history, queue times, source, vetting and priority so they
+	// can be read while the host is being provisioned and mu is held for the whole attempt
+	imu sync.Mutex
 }
 
 func NewHost(identity string, conf *config.Config) *Host {
+	return NewHostAt(identity, conf, time.Now())
+}
+
+// NewHostAt creates a host that was discovered at a specific time
+func NewHostAt(identity string, conf *config.Config, discovered time.Time) *Host {
 	return &Host{
 		Identity:    identity,
 		provisioned: false,
-		discovered:  time.Now(),
+		discovered:  discovered,
+		now:         time.Now,
 		mu:          &sync.Mutex{},
 		replylock:   &sync.Mutex{},
 		token:       conf.Token,
 		cfg:         conf,
+		state:       stateMachine{transitions: []Transition{{State: StateQueued, Time: discovered.UTC()}}},
 	}
 }
 
+// UseConfig sets the configuration to use for future provisioning attempts, an
+// attempt that is already in progress will complete using its existing configuration
+func (h *Host) UseConfig(conf *config.Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.cfg = conf
+	h.token = conf.Token
+}
+
+// UseClock sets the source of the current time, it should be the clock used to set the discovered and queued times
+func (h *Host) UseClock(now func() time.Time) {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	h.now = now
+}
+
 func (h *Host) DiscoveredTime() time.Time {
 	return h.discovered
 }
 
+// StartAttempt records the start of a provisioning attempt and returns the attempt number
+func (h *Host) StartAttempt() int {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	h.Attempt++
+
+	return h.Attempt
+}
+
+// FailAttempt records the error that ended the current attempt
+func (h *Host) FailAttempt(err error) {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	h.LastError = err.Error()
+}
+
+// Attempts is the number of attempts started and the error from the last failed attempt
+func (h *Host) Attempts() (int, string) {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	return h.Attempt, h.LastError
+}
+
+// RestoreAttempts sets the attempt history, used when resuming a host from a durable queue
+func (h *Host) RestoreAttempts(attempts int, lastError string) {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	h.Attempt = attempts
+	h.LastError = lastError
+}
+
+// SetQueued records when the host was queued again for another attempt, staleness is measured from then
+func (h *Host) SetQueued(t time.Time) {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	h.queued = t
+	h.transition(StateQueued, t)
+}
+
+// MarkEventSourced records that the node was found by its startup event rather than by discovery
+func (h *Host) MarkEventSourced() {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	h.eventSourced = true
+}
+
+// EventSourced is true when the node was found by its startup event
+func (h *Host) EventSourced() bool {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	return h.eventSourced
+}
+
+// MarkVetted records that the node was confirmed to match the discovery filter
+func (h *Host) MarkVetted() {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	h.vetted = true
+}
+
+// Vetted is true once the node was confirmed to match the discovery filter
+func (h *Host) Vetted() bool {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	return h.vetted
+}
+
+// Priority is the provisioning priority and if it was resolved
+func (h *Host) Priority() (int, bool) {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	return h.priority, h.priorityKnown
+}
+
+// SetPriority sets the provisioning priority, used when resuming a host from a durable queue
+func (h *Host) SetPriority(priority int) {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	h.priority = priority
+	h.priorityKnown = true
+}
+
+// ResolvePriority fetches the JWT and inventory as needed to determine the provisioning priority,
+// the priority is 0 when it could not be found and the fetched data is reused when provisioning
+func (h *Host) ResolvePriority(ctx context.Context, fw *choria.Framework) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fw = fw
+	h.log = fw.Logger(h.Identity)
+
+	h.imu.Lock()
+	h.priorityKnown = true
+	h.imu.Unlock()
+
+	if h.cfg.Priority.JWTExtension != "" && h.cfg.Features.JWT {
+		err := h.fetchJWT(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("could not fetch JWT: %s", err)
+		}
+
+		err = h.validateJWT()
+		if err != nil {
+			return 0, fmt.Errorf("could not validate JWT: %s", err)
+		}
+	}
+
+	if h.cfg.Priority.Fact != "" {
+		err := h.fetchInventory(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("could not fetch inventory: %s", err)
+		}
+	}
+
+	priority := h.priorityFromNode()
+	h.SetPriority(priority)
+
+	return priority, nil
+}
+
+// priorityFromNode finds the priority in the JWT extensions or facts, preferring the JWT
+func (h *Host) priorityFromNode() int {
+	if h.cfg.Priority.JWTExtension != "" && h.JWT != nil {
+		v, ok := h.JWT.Extensions[h.cfg.Priority.JWTExtension]
+		if ok {
+			j, err := json.Marshal(v)
+			if err == nil {
+				return int(gjson.ParseBytes(j).Int())
+			}
+		}
+	}
+
+	if h.cfg.Priority.Fact != "" && h.Metadata != "" {
+		return int(gjson.Get(h.Metadata, "facts."+h.cfg.Priority.Fact).Int())
+	}
+
+	return 0
+}
+
+// Provisioned is true once the node was configured and restarted
+func (h *Host) Provisioned() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.provisioned
+}
+
+// ShutdownRequested is true when the helper or admission policy shut the node down
+func (h *Host) ShutdownRequested() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.shutdownRequested
+}
+
+// Provision provisions the node, true is returned when it should be left alone for a while after
 func (h *Host) Provision(ctx context.Context, fw *choria.Framework) (bool, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	ctx, span := tracer.Start(ctx, "provision", trace.WithAttributes(h.spanAttributes()...))
+
+	delay, err := h.provision(ctx, fw)
+	h.transition(h.finalState(err), time.Now())
+	span.SetAttributes(
+		attribute.Bool("choria.provisioned", h.provisioned),
+		attribute.Bool("choria.shutdown", h.shutdownRequested),
+		attribute.Bool("choria.planned", h.plan != nil),
+	)
+	endSpan(span, err)
+
+	return delay, err
+}
+
+func (h *Host) provision(ctx context.Context, fw *choria.Framework) (bool, error) {
 	if h.provisioned {
 		return true, nil
 	}
 
 	h.fw = fw
 	h.log = fw.Logger(h.Identity)
+	h.plan = nil
+	h.audit = Audit{}
 
-	if !h.discovered.IsZero() {
-		since := time.Since(h.discovered)
-		if since > 2*h.cfg.IntervalDuration {
+	h.imu.Lock()
+	waiting := h.discovered
+	if !h.queued.IsZero() {
+		waiting = h.queued
+	}
+	now := h.now
+	h.imu.Unlock()
+
+	if !waiting.IsZero() {
+		since := now().Sub(waiting)
+		if since > h.cfg.Timeouts.StaleDuration {
 			return false, fmt.Errorf("skipping node that's been waiting %v", since)
 		}
 	}
 
 	if h.cfg.Features.JWT {
-		err := h.fetchJWT(ctx)
+		h.transition(StateJWT, time.Now())
+
+		err := h.step("jwt", time.Now(), h.fetchJWT(ctx))
 		if err != nil {
 			return false, fmt.Errorf("could not fetch and validate JWT: %s: %s", h.Identity, err)
 		}
 
-		err = h.validateJWT()
+		err = h.step("validate_jwt", time.Now(), h.validateJWT())
 		if err != nil {
 			return false, fmt.Errorf("could not validate JWT: %s: %s", h.Identity, err)
 		}
 	}
 
 	if h.cfg.Features.ED25519 {
-		err := h.fetchEd25519PubKey(ctx)
+		h.transition(StateED25519, time.Now())
+
+		err := h.step("ed25519", time.Now(), h.fetchEd25519PubKey(ctx))
 		if err != nil {
 			return false, fmt.Errorf("could not fetch ed25519 public key: %s", err)
 		}
 	}
 
-	err := h.fetchInventory(ctx)
+	h.transition(StateInventory, time.Now())
+
+	err := h.step("inventory", time.Now(), h.fetchInventory(ctx))
 	if err != nil {
 		return false, fmt.Errorf("could not provision %s: %s", h.Identity, err)
 	}
 
 	if h.cfg.Features.PKI {
-		err = h.fetchCSR(ctx)
+		h.transition(StateCSR, time.Now())
+
+		err = h.step("csr", time.Now(), h.fetchCSR(ctx))
 		if err != nil {
 			return false, fmt.Errorf("could not provision %s: %s", h.Identity, err)
 		}
 
-		err = h.validateCSR()
+		err = h.step("validate_csr", time.Now(), h.validateCSR())
 		if err != nil {
 			return false, fmt.Errorf("could not provision %s: %s", h.Identity, err)
 		}
 	}
 
+	if h.cfg.RegoPolicy != "" {
+		h.transition(StatePolicy, time.Now())
+
+		started := time.Now()
+		decision, err := h.evaluatePolicy(ctx)
+		h.step("policy", started, err)
+		if err != nil {
+			policyDecisionCtr.WithLabelValues(h.cfg.Site, "error").Inc()
+			return false, fmt.Errorf("could not evaluate admission policy: %s", err)
+		}
+
+		policyDecisionCtr.WithLabelValues(h.cfg.Site, decision.Decision).Inc()
+		h.log.Infof("Admission policy decision %s: %s", decision.Decision, decision.Reason)
+		h.audit.PolicyDecision = decision.Decision
+
+		switch decision.Decision {
+		case PolicyDeny:
+			h.log.Warnf("Shutting down host based on admission policy: %s", decision.Reason)
+			return true, h.requestShutdown(ctx, decision.Reason)
+
+		case PolicyDefer:
+			return false, fmt.Errorf("%w by admission policy: %s", ErrDeferred, decision.Reason)
+		}
+	}
+
+	h.transition(StateHelper, time.Now())
+
+	started := time.Now()
 	config, err := h.getConfig(ctx)
+	h.step("helper", started, err)
 	if err != nil {
 		helperErrCtr.WithLabelValues(h.cfg.Site).Inc()
 		return false, err
 	}
 
+	h.audit.HelperMessage = config.Msg
+
 	if config.Defer {
-		return false, fmt.Errorf("configuration defered: %s", config.Msg)
+		h.audit.HelperDecision = DecisionDefer
+		return false, fmt.Errorf("%w by helper: %s", ErrDeferred, config.Msg)
 	}
 
 	if config.Shutdown {
+		h.audit.HelperDecision = DecisionShutdown
+
 		if config.Msg == "" {
 			h.log.Warnf("Shutting down host based on helper output, no reason given")
 		} else {
 			h.log.Warnf("Shutting down host based on helper output: %v", config.Msg)
 		}
 
-		return true, h.shutdown(ctx)
+		return true, h.requestShutdown(ctx, config.Msg)
 	}
 
 	h.config = config.Configuration
@@ -157,9 +442,11 @@ func (h *Host) Provision(ctx context.Context, fw *choria.Framework) (bool, error
 	h.actionPolicies = make(map[string]interface{})
 	h.opaPolicies = make(map[string]interface{})
 	h.upgradeTargetVersion = config.UpgradeVersion
+	h.audit.HelperDecision = DecisionConfigure
+	h.audit.CertificateSerial = certificateSerial(h.cert)
 
 	if h.cfg.Features.ED25519 {
-		err = h.generateServerJWT(config)
+		err = h.step("server_jwt", time.Now(), h.generateServerJWT(config))
 		if err != nil {
 			return false, err
 		}
@@ -178,14 +465,25 @@ func (h *Host) Provision(ctx context.Context, fw *choria.Framework) (bool, error
 	}
 
 	if h.key != "" {
-		err = h.encryptPrivateKey()
+		err = h.step("encrypt_key", time.Now(), h.encryptPrivateKey())
 		if err != nil {
 			return false, err
 		}
 	}
 
+	// everything up to here only reads from the node, a dry run stops before changing it
+	if h.cfg.DryRun {
+		return false, h.recordPlan(h.configurePlan())
+	}
+
 	if h.cfg.Features.VersionUpgrades && h.upgradeTargetVersion != "" {
+		h.audit.UpgradeVersion = h.upgradeTargetVersion
+		h.transition(StateUpgrade, time.Now())
+		h.notify(EventUpgrading)
+
+		started := time.Now()
 		skipped, err := h.handleHostUpgrade(ctx)
+		h.step("upgrade", started, err)
 		switch {
 		case err == nil:
 			if skipped {
@@ -201,21 +499,42 @@ func (h *Host) Provision(ctx context.Context, fw *choria.Framework) (bool, error
 		}
 	}
 
-	err = h.configure(ctx)
+	h.transition(StateConfigure, time.Now())
+
+	err = h.step("configure", time.Now(), h.configure(ctx))
 	if err != nil {
 		return false, fmt.Errorf("configuration failed: %s", err)
 	}
+	h.notify(EventConfigured)
 
-	err = h.restart(ctx)
+	h.transition(StateRestart, time.Now())
+
+	err = h.step("restart", time.Now(), h.restart(ctx))
 	if err != nil {
 		return false, fmt.Errorf("restart failed: %s", err)
 	}
+	h.notify(EventRestarted)
 
 	h.provisioned = true
 
 	return true, nil
 }
 
+func (h *Host) requestShutdown(ctx context.Context, reason string) error {
+	if h.cfg.DryRun {
+		return h.recordPlan(&Plan{Identity: h.Identity, Time: time.Now().UTC(), Action: PlanShutdown, Reason: reason})
+	}
+
+	err := h.step("shutdown", time.Now(), h.shutdown(ctx))
+	if err != nil {
+		return err
+	}
+
+	h.shutdownRequested = true
+
+	return nil
+}
+
 func (h *Host) handleHostUpgrade(ctx context.Context) (bool, error) {
 	if h.cfg.Features.VersionUpgrades {
 		if h.cfg.UpgradesRepo == "" && !h.cfg.UpgradesOptional {
@@ -347,6 +666,7 @@ func (h *Host) generateServerJWT(c *ConfigResponse) error {
 	}
 
 	h.signedServerJWT = signed
+	h.audit.ServerJWTID = claims.ID
 
 	return nil
 }
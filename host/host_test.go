@@ -6,6 +6,7 @@ package host
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
@@ -13,10 +14,15 @@ import (
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -25,6 +31,11 @@ import (
 	"github.com/choria-io/provisioner/config"
 	"github.com/choria-io/tokens"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -105,6 +116,7 @@ var _ = Describe("Host", func() {
 			until := time.Until(claims.ExpiresAt.Time)
 			Expect(until).To(BeNumerically("~", time.Hour, time.Second))
 			Expect(claims.PublicKey).To(Equal(h.edPubK))
+			Expect(h.audit.ServerJWTID).To(Equal(claims.ID))
 		})
 
 		It("Should use the configured claims", func() {})
@@ -149,6 +161,351 @@ var _ = Describe("Host", func() {
 		})
 	})
 
+	Describe("evaluatePolicy", func() {
+		BeforeEach(func() {
+			h.cfg.RegoPolicy = "testdata/policy.rego"
+
+			csr, _, err := gencsr("ginkgo.example.net", []string{"alt.example.net"})
+			Expect(err).ToNot(HaveOccurred())
+			h.CSR.CSR = string(csr)
+			h.Metadata = `{"facts":{"role":"web"},"classes":["one"]}`
+		})
+
+		It("Should build the correct input", func() {
+			input, err := h.policyInput()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input.Identity).To(Equal("ginkgo.example.net"))
+			Expect(input.Facts).To(Equal(map[string]any{"role": "web"}))
+			Expect(input.Classes).To(Equal([]string{"one"}))
+			Expect(input.CSRNames).To(Equal([]string{"ginkgo.example.net", "alt.example.net"}))
+		})
+
+		It("Should allow matching hosts", func() {
+			decision, err := h.evaluatePolicy(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decision).To(Equal(&PolicyDecision{Decision: PolicyAllow, Reason: "web server"}))
+		})
+
+		It("Should deny hosts", func() {
+			h.Identity = "node.denied.example.net"
+			decision, err := h.evaluatePolicy(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decision).To(Equal(&PolicyDecision{Decision: PolicyDeny, Reason: "denied domain"}))
+		})
+
+		It("Should defer hosts", func() {
+			h.Metadata = `{"facts":{"role":"db"}}`
+			decision, err := h.evaluatePolicy(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decision).To(Equal(&PolicyDecision{Decision: PolicyDefer, Reason: "unknown role"}))
+		})
+
+		It("Should compile policies once until they change", func() {
+			file := filepath.Join(GinkgoT().TempDir(), "policy.rego")
+			policy, err := os.ReadFile("testdata/policy.rego")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(file, policy, 0600)).To(Succeed())
+			h.cfg.RegoPolicy = file
+
+			_, err = h.evaluatePolicy(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			compiled := policies[file]
+
+			decision, err := h.evaluatePolicy(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decision.Decision).To(Equal(PolicyAllow))
+			Expect(policies[file]).To(BeIdenticalTo(compiled))
+
+			Expect(os.WriteFile(file, []byte("package io.choria.provisioner\n\ndecision := \"deny\"\n"), 0600)).To(Succeed())
+			Expect(os.Chtimes(file, time.Now(), time.Now().Add(time.Minute))).To(Succeed())
+
+			decision, err = h.evaluatePolicy(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decision.Decision).To(Equal(PolicyDeny))
+			Expect(policies[file]).ToNot(BeIdenticalTo(compiled))
+		})
+
+		It("Should handle missing policies", func() {
+			h.cfg.RegoPolicy = "testdata/missing.rego"
+			_, err := h.evaluatePolicy(context.Background())
+			Expect(err).To(MatchError(ContainSubstring("could not read policy")))
+		})
+	})
+
+	Describe("priorityFromNode", func() {
+		BeforeEach(func() {
+			h.Metadata = `{"facts":{"provisioning":{"priority":"20"}}}`
+			h.JWT = &tokens.ProvisioningClaims{Extensions: tokens.MapClaims{"priority": 30.0}}
+		})
+
+		It("Should default to 0", func() {
+			Expect(h.priorityFromNode()).To(Equal(0))
+
+			h.cfg.Priority.Fact = "missing"
+			Expect(h.priorityFromNode()).To(Equal(0))
+		})
+
+		It("Should use facts", func() {
+			h.cfg.Priority.Fact = "provisioning.priority"
+			Expect(h.priorityFromNode()).To(Equal(20))
+		})
+
+		It("Should prefer the JWT extension", func() {
+			h.cfg.Priority.Fact = "provisioning.priority"
+			h.cfg.Priority.JWTExtension = "priority"
+			Expect(h.priorityFromNode()).To(Equal(30))
+
+			h.cfg.Priority.JWTExtension = "missing"
+			Expect(h.priorityFromNode()).To(Equal(20))
+		})
+	})
+
+	Describe("Dry Run", func() {
+		BeforeEach(func() {
+			h.mu = &sync.Mutex{}
+			h.cfg.DryRun = true
+			h.cfg.DryRunDirectory = GinkgoT().TempDir()
+		})
+
+		readPlan := func() *Plan {
+			j, err := os.ReadFile(filepath.Join(h.cfg.DryRunDirectory, "ginkgo.example.net.json"))
+			Expect(err).ToNot(HaveOccurred())
+
+			plan := &Plan{}
+			Expect(json.Unmarshal(j, plan)).To(Succeed())
+
+			return plan
+		}
+
+		It("Should describe the configuration that would be sent", func() {
+			h.config = map[string]string{"plugin.choria.srv_domain": "example.net"}
+			h.key = "secret"
+			h.sslDir = "/etc/choria/ssl"
+			h.version = "0.29.0"
+			h.upgradeTargetVersion = "0.30.0"
+			h.cfg.Features.VersionUpgrades = true
+
+			Expect(h.Planned()).To(BeFalse())
+			Expect(h.recordPlan(h.configurePlan())).To(Succeed())
+			Expect(h.Planned()).To(BeTrue())
+
+			plan := readPlan()
+			Expect(plan.Action).To(Equal(PlanConfigure))
+			Expect(plan.Configuration).To(Equal(h.config))
+			Expect(plan.SSLDir).To(Equal("/etc/choria/ssl"))
+			Expect(plan.KeySet).To(BeTrue())
+			Expect(plan.UpgradeVersion).To(Equal("0.30.0"))
+		})
+
+		It("Should not shut nodes down", func() {
+			Expect(h.requestShutdown(context.Background(), "denied by policy")).To(Succeed())
+			Expect(h.shutdownRequested).To(BeFalse())
+
+			plan := readPlan()
+			Expect(plan.Action).To(Equal(PlanShutdown))
+			Expect(plan.Reason).To(Equal("denied by policy"))
+		})
+
+		It("Should not log secrets when no directory is set", func() {
+			var logged bytes.Buffer
+			h.log.Logger.Out = &logged
+			h.cfg.DryRunDirectory = ""
+			h.config = map[string]string{"plugin.choria.provisioning.password": "s3cret"}
+			h.signedServerJWT = "eyJhbGciOi.server.jwt"
+			h.cert = "PUBLIC CERTIFICATE"
+
+			Expect(h.recordPlan(h.configurePlan())).To(Succeed())
+			Expect(logged.String()).To(ContainSubstring("plugin.choria.provisioning.password"))
+			Expect(logged.String()).To(ContainSubstring("PUBLIC CERTIFICATE"))
+			Expect(logged.String()).ToNot(ContainSubstring("s3cret"))
+			Expect(logged.String()).ToNot(ContainSubstring("eyJhbGciOi"))
+
+			// the plan itself is not changed
+			Expect(h.plan.Configuration["plugin.choria.provisioning.password"]).To(Equal("s3cret"))
+			Expect(h.plan.ServerJWT).To(Equal("eyJhbGciOi.server.jwt"))
+		})
+
+		It("Should reject identities that are not file names", func() {
+			h.Identity = "../ginkgo.example.net"
+			Expect(h.recordPlan(h.configurePlan())).To(MatchError(`can not write a dry run plan for identity "../ginkgo.example.net"`))
+		})
+	})
+
+	Describe("Audit", func() {
+		It("Should record steps and their errors", func() {
+			h.mu = &sync.Mutex{}
+
+			Expect(h.step("inventory", time.Now().Add(-time.Second), nil)).To(Succeed())
+			Expect(h.step("configure", time.Now(), fmt.Errorf("timeout"))).To(MatchError("timeout"))
+
+			audit := h.Audit()
+			Expect(audit.Steps).To(HaveLen(2))
+			Expect(audit.Steps[0].Name).To(Equal("inventory"))
+			Expect(audit.Steps[0].Duration).To(BeNumerically(">=", 1))
+			Expect(audit.Steps[0].Error).To(BeEmpty())
+			Expect(audit.Steps[1].Name).To(Equal("configure"))
+			Expect(audit.Steps[1].Error).To(Equal("timeout"))
+		})
+
+		It("Should find certificate serials", func() {
+			pk, err := rsa.GenerateKey(rand.Reader, 1024)
+			Expect(err).ToNot(HaveOccurred())
+
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(0xc0ffee),
+				Subject:      pkix.Name{CommonName: h.Identity},
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+
+			der, err := x509.CreateCertificate(rand.Reader, template, template, &pk.PublicKey, pk)
+			Expect(err).ToNot(HaveOccurred())
+
+			cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+			Expect(certificateSerial(string(cert))).To(Equal("c0ffee"))
+			Expect(certificateSerial("not a certificate")).To(BeEmpty())
+		})
+	})
+
+	Describe("Attempt Details", func() {
+		It("Should be readable while the host is being provisioned", func() {
+			h = NewHost("ginkgo.example.net", h.cfg)
+			h.StartAttempt()
+			h.SetPriority(10)
+			h.MarkEventSourced()
+
+			// provisioning holds the host lock for the whole attempt
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			read := make(chan struct{})
+			go func() {
+				defer close(read)
+
+				attempts, _ := h.Attempts()
+				priority, known := h.Priority()
+				Expect(attempts).To(Equal(1))
+				Expect(priority).To(Equal(10))
+				Expect(known).To(BeTrue())
+				Expect(h.EventSourced()).To(BeTrue())
+			}()
+
+			Eventually(read).Should(BeClosed())
+		})
+	})
+
+	Describe("State", func() {
+		var discovered time.Time
+
+		BeforeEach(func() {
+			discovered = time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+			h = NewHostAt("ginkgo.example.net", h.cfg, discovered)
+		})
+
+		It("Should start queued", func() {
+			state, since := h.State()
+			Expect(state).To(Equal(StateQueued))
+			Expect(since).To(Equal(discovered))
+		})
+
+		It("Should record transitions and notify the observer", func() {
+			var seen []string
+			h.OnTransition(func(identity string, state string) {
+				Expect(identity).To(Equal("ginkgo.example.net"))
+				seen = append(seen, state)
+			})
+
+			h.transition(StateJWT, discovered.Add(time.Second))
+			h.transition(StateHelper, discovered.Add(2*time.Second))
+
+			state, since := h.State()
+			Expect(state).To(Equal(StateHelper))
+			Expect(since).To(Equal(discovered.Add(2 * time.Second)))
+			Expect(seen).To(Equal([]string{StateJWT, StateHelper}))
+			Expect(h.Transitions()).To(Equal([]Transition{
+				{State: StateQueued, Time: discovered},
+				{State: StateJWT, Time: discovered.Add(time.Second)},
+				{State: StateHelper, Time: discovered.Add(2 * time.Second)},
+			}))
+		})
+
+		It("Should only keep recent transitions", func() {
+			for i := range maxTransitions + 10 {
+				h.transition(StateFailed, discovered.Add(time.Duration(i)*time.Second))
+			}
+
+			transitions := h.Transitions()
+			Expect(transitions).To(HaveLen(maxTransitions))
+			Expect(transitions[maxTransitions-1].Time).To(Equal(discovered.Add(time.Duration(maxTransitions+9) * time.Second)))
+		})
+
+		It("Should determine the final state of an attempt", func() {
+			Expect(h.finalState(fmt.Errorf("%w by helper: testing", ErrDeferred))).To(Equal(StateDeferred))
+			Expect(h.finalState(fmt.Errorf("rpc failed"))).To(Equal(StateFailed))
+			Expect(h.finalState(nil)).To(Equal(StateUpgraded))
+
+			h.provisioned = true
+			Expect(h.finalState(nil)).To(Equal(StateProvisioned))
+
+			h.shutdownRequested = true
+			Expect(h.finalState(nil)).To(Equal(StateShutdown))
+
+			h.plan = &Plan{}
+			Expect(h.finalState(nil)).To(Equal(StatePlanned))
+		})
+	})
+
+	Describe("Tracing", func() {
+		exporter := tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+		BeforeEach(func() {
+			exporter.Reset()
+			h.cfg.Site = "ginkgo"
+			h.Attempt = 2
+		})
+
+		It("Should trace every RPC attempt", func() {
+			tries := 0
+			err := h.rpcWrapper(context.Background(), "inventory", 2, func(context.Context) error {
+				tries++
+				if tries == 1 {
+					return fmt.Errorf("timeout")
+				}
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(2))
+			Expect(spans[0].Name).To(Equal("rpc inventory"))
+			Expect(spans[0].Status.Code).To(Equal(codes.Error))
+			Expect(spans[0].Status.Description).To(Equal("timeout"))
+			Expect(spans[1].Status.Code).To(Equal(codes.Unset))
+			Expect(spans[1].Attributes).To(ContainElements(
+				attribute.String("choria.identity", "ginkgo.example.net"),
+				attribute.String("choria.site", "ginkgo"),
+				attribute.Int("choria.attempt", 2),
+				attribute.String("choria.action", "inventory"),
+				attribute.Int("choria.try", 2),
+			))
+		})
+
+		It("Should trace helper runs", func() {
+			h.mu = &sync.Mutex{}
+			h.cfg.Helper = "/bin/false"
+			h.cfg.Timeouts.HelperDuration = time.Second
+
+			_, err := h.getConfig(context.Background())
+			Expect(err).To(HaveOccurred())
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name).To(Equal("helper"))
+			Expect(spans[0].Status.Code).To(Equal(codes.Error))
+		})
+	})
+
 	Describe("encryptPrivateKey", func() {
 		It("Should correctly encrypt the private key", func() {
 			Expect(h.encryptPrivateKey()).To(MatchError("no key to encrypt"))
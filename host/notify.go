@@ -0,0 +1,34 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package host
+
+// Events a host emits while it is being provisioned
+const (
+	// EventUpgrading is emitted before the node is asked to upgrade
+	EventUpgrading = "upgrading"
+	// EventConfigured is emitted once the node accepted its configuration
+	EventConfigured = "configured"
+	// EventRestarted is emitted once the node was asked to restart
+	EventRestarted = "restarted"
+)
+
+// Notifier is called with the identity of a node and the event it reached while being provisioned
+type Notifier func(identity string, event string)
+
+// OnEvent sets a function to call as the node progresses through provisioning
+func (h *Host) OnEvent(n Notifier) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.notifier = n
+}
+
+func (h *Host) notify(event string) {
+	if h.notifier == nil {
+		return
+	}
+
+	h.notifier(h.Identity, event)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package host
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+const (
+	// PolicyAllow allows provisioning to continue to the helper
+	PolicyAllow = "allow"
+	// PolicyDeny shuts the node down
+	PolicyDeny = "deny"
+	// PolicyDefer stops provisioning, the node will be tried again later
+	PolicyDefer = "defer"
+
+	policyQuery = "data.io.choria.provisioner"
+)
+
+// PolicyInput is the input document supplied to the admission policy
+type PolicyInput struct {
+	Identity string         `json:"identity"`
+	Site     string         `json:"site"`
+	Facts    map[string]any `json:"facts"`
+	Classes  []string       `json:"classes"`
+	JWT      any            `json:"jwt"`
+	CSRNames []string       `json:"csr_names"`
+}
+
+// preparedPolicy is a compiled policy and the state of the file it was compiled from
+type preparedPolicy struct {
+	modTime time.Time
+	size    int64
+	query   rego.PreparedEvalQuery
+}
+
+var (
+	// policies are compiled once and shared by all hosts until the file changes
+	policies   = make(map[string]*preparedPolicy)
+	policiesMu sync.Mutex
+)
+
+// preparePolicy compiles the policy in file, the compiled query is reused until the file changes
+func preparePolicy(ctx context.Context, file string) (rego.PreparedEvalQuery, error) {
+	st, err := os.Stat(file)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("could not read policy: %s", err)
+	}
+
+	// held while compiling so a changed policy is compiled once rather than by every worker
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+
+	cached, ok := policies[file]
+	if ok && cached.modTime.Equal(st.ModTime()) && cached.size == st.Size() {
+		return cached.query, nil
+	}
+
+	policy, err := os.ReadFile(file)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("could not read policy: %s", err)
+	}
+
+	pq, err := rego.New(rego.Query(policyQuery), rego.Module(file, string(policy))).PrepareForEval(ctx)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("could not prepare policy %s: %s", file, err)
+	}
+
+	policies[file] = &preparedPolicy{modTime: st.ModTime(), size: st.Size(), query: pq}
+
+	return pq, nil
+}
+
+// PolicyDecision is the outcome of evaluating the admission policy
+type PolicyDecision struct {
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+}
+
+func (h *Host) policyInput() (*PolicyInput, error) {
+	input := &PolicyInput{
+		Identity: h.Identity,
+		Site:     h.cfg.Site,
+		Facts:    map[string]any{},
+		Classes:  []string{},
+		CSRNames: []string{},
+	}
+
+	if h.JWT != nil {
+		input.JWT = h.JWT
+	}
+
+	if h.Metadata != "" {
+		inventory := struct {
+			Facts   map[string]any `json:"facts"`
+			Classes []string       `json:"classes"`
+		}{}
+
+		err := json.Unmarshal([]byte(h.Metadata), &inventory)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse inventory: %s", err)
+		}
+
+		if inventory.Facts != nil {
+			input.Facts = inventory.Facts
+		}
+		if inventory.Classes != nil {
+			input.Classes = inventory.Classes
+		}
+	}
+
+	if h.CSR != nil && h.CSR.CSR != "" {
+		block, _ := pem.Decode([]byte(h.CSR.CSR))
+		if block == nil {
+			return nil, fmt.Errorf("could not parse CSR: invalid PEM data")
+		}
+
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse CSR: %s", err)
+		}
+
+		input.CSRNames = append(input.CSRNames, csr.Subject.CommonName)
+		input.CSRNames = append(input.CSRNames, csr.DNSNames...)
+	}
+
+	return input, nil
+}
+
+// evaluatePolicy evaluates the rego_policy against the host, changes to the policy take effect immediately
+func (h *Host) evaluatePolicy(ctx context.Context) (*PolicyDecision, error) {
+	pq, err := preparePolicy(ctx, h.cfg.RegoPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := h.policyInput()
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := pq.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, fmt.Errorf("could not evaluate policy %s: %s", h.cfg.RegoPolicy, err)
+	}
+
+	decision := &PolicyDecision{Decision: PolicyDefer, Reason: "no decision from policy"}
+
+	if len(rs) != 1 || len(rs[0].Expressions) != 1 {
+		return decision, nil
+	}
+
+	doc, ok := rs[0].Expressions[0].Value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid result from policy %s: expected an object", h.cfg.RegoPolicy)
+	}
+
+	if reason, ok := doc["reason"].(string); ok {
+		decision.Reason = reason
+	}
+
+	d, ok := doc["decision"].(string)
+	if !ok {
+		return decision, nil
+	}
+
+	switch d {
+	case PolicyAllow, PolicyDeny, PolicyDefer:
+		decision.Decision = d
+	default:
+		return nil, fmt.Errorf("invalid decision %q from policy %s", d, h.cfg.RegoPolicy)
+	}
+
+	return decision, nil
+}
@@ -17,6 +17,8 @@ import (
 	"github.com/choria-io/go-choria/client/rpcutilclient"
 	"github.com/choria-io/go-choria/providers/agent/mcorpc/golang/provision"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (h *Host) rpcUtilClient(ctx context.Context, action string, tries int, cb func(context.Context, *rpcutilclient.RpcutilClient) error) error {
@@ -79,7 +81,9 @@ func (h *Host) rpcWrapper(ctx context.Context, action string, tries int, cb func
 		obs := prometheus.NewTimer(rpcDuration.WithLabelValues(h.cfg.Site, action))
 		defer obs.ObserveDuration()
 
-		err := cb(tctx)
+		sctx, span := tracer.Start(tctx, "rpc "+action, trace.WithAttributes(h.spanAttributes(attribute.String("choria.action", action), attribute.Int("choria.try", try))...))
+		err := cb(sctx)
+		endSpan(span, err)
 		if err != nil {
 			h.log.Errorf("rpc handler for %s failed: %s", action, err)
 			return fmt.Errorf("rpc handler failed: %s", err)
@@ -95,7 +99,7 @@ func (h *Host) rpcWrapper(ctx context.Context, action string, tries int, cb func
 }
 
 func (h *Host) upgrade(ctx context.Context) error {
-	return h.provisionClient(ctx, "release_update", 3, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
+	return h.provisionClient(ctx, "release_update", h.cfg.Retries.Upgrade, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
 		h.log.Infof("Updating node to %s", h.upgradeTargetVersion)
 
 		res, err := pc.ReleaseUpdate(h.cfg.UpgradesRepo, h.token, h.upgradeTargetVersion).Do(ctx)
@@ -122,7 +126,7 @@ func (h *Host) upgrade(ctx context.Context) error {
 }
 
 func (h *Host) restart(ctx context.Context) error {
-	return h.provisionClient(ctx, "restart", 3, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
+	return h.provisionClient(ctx, "restart", h.cfg.Retries.Restart, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
 		h.log.Info("Restarting node")
 
 		res, err := pc.Restart(h.token).Splay(1).Do(ctx)
@@ -148,7 +152,7 @@ func (h *Host) restart(ctx context.Context) error {
 }
 
 func (h *Host) shutdown(ctx context.Context) error {
-	return h.provisionClient(ctx, "shutdown", 3, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
+	return h.provisionClient(ctx, "shutdown", h.cfg.Retries.Shutdown, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
 		h.log.Info("Shutting down node")
 
 		res, err := pc.Shutdown(h.token).Do(ctx)
@@ -176,7 +180,7 @@ func (h *Host) shutdown(ctx context.Context) error {
 }
 
 func (h *Host) configure(ctx context.Context) error {
-	return h.provisionClient(ctx, "configure", 5, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
+	return h.provisionClient(ctx, "configure", h.cfg.Retries.Configure, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
 		if len(h.config) == 0 {
 			return fmt.Errorf("empty configuration")
 		}
@@ -231,7 +235,7 @@ func (h *Host) fetchEd25519PubKey(ctx context.Context) error {
 		return nil
 	}
 
-	return h.provisionClient(ctx, "gen25519", 5, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
+	return h.provisionClient(ctx, "gen25519", h.cfg.Retries.ED25519, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
 		h.log.Infof("Fetching ED25519 public key")
 		h.nonce, _ = choria.NewRequestID()
 
@@ -306,7 +310,7 @@ func (h *Host) fetchJWT(ctx context.Context) (err error) {
 		return nil
 	}
 
-	return h.provisionClient(ctx, "jwt", 3, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
+	return h.provisionClient(ctx, "jwt", h.cfg.Retries.JWT, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
 		h.log.Info("Fetching JWT")
 
 		res, err := pc.Jwt(h.token).Do(ctx)
@@ -338,7 +342,7 @@ func (h *Host) fetchInventory(ctx context.Context) (err error) {
 		return nil
 	}
 
-	return h.rpcUtilClient(ctx, "inventory", 5, func(ctx context.Context, rpcc *rpcutilclient.RpcutilClient) error {
+	return h.rpcUtilClient(ctx, "inventory", h.cfg.Retries.Inventory, func(ctx context.Context, rpcc *rpcutilclient.RpcutilClient) error {
 		h.log.Info("Fetching Inventory")
 
 		res, err := rpcc.Inventory().Do(ctx)
@@ -374,7 +378,7 @@ func (h *Host) fetchInventory(ctx context.Context) (err error) {
 }
 
 func (h *Host) fetchCSR(ctx context.Context) error {
-	return h.provisionClient(ctx, "gencsr", 1, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
+	return h.provisionClient(ctx, "gencsr", h.cfg.Retries.CSR, func(ctx context.Context, pc *provclient.ChoriaProvisionClient) error {
 		h.log.Info("Fetching CSR")
 
 		res, err := pc.Gencsr(h.token).Cn(h.Identity).Do(ctx)
@@ -0,0 +1,135 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package host
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// States a host moves through while it is being provisioned
+const (
+	// StateQueued is a host waiting in the work queue
+	StateQueued = "queued"
+	// StateJWT is a host whose provisioning JWT is being fetched and validated
+	StateJWT = "jwt"
+	// StateED25519 is a host whose ed25519 public key is being fetched
+	StateED25519 = "ed25519"
+	// StateInventory is a host whose inventory is being fetched
+	StateInventory = "inventory"
+	// StateCSR is a host whose certificate signing request is being fetched and validated
+	StateCSR = "csr"
+	// StatePolicy is a host being evaluated by the admission policy
+	StatePolicy = "policy"
+	// StateHelper is a host the helper is producing configuration for
+	StateHelper = "helper"
+	// StateUpgrade is a host being upgraded
+	StateUpgrade = "upgrade"
+	// StateConfigure is a host being configured
+	StateConfigure = "configure"
+	// StateRestart is a host being restarted
+	StateRestart = "restart"
+	// StateProvisioned is a host that was provisioned
+	StateProvisioned = "provisioned"
+	// StateUpgraded is a host that was upgraded and will be provisioned again once it restarted
+	StateUpgraded = "upgraded"
+	// StateShutdown is a host that was shut down
+	StateShutdown = "shutdown"
+	// StatePlanned is a host that was planned in dry run mode
+	StatePlanned = "planned"
+	// StateDeferred is a host the helper or admission policy deferred
+	StateDeferred = "deferred"
+	// StateFailed is a host that failed to provision
+	StateFailed = "failed"
+)
+
+// States are all the states a host can be in, in the order they are usually reached
+var States = []string{
+	StateQueued, StateJWT, StateED25519, StateInventory, StateCSR, StatePolicy, StateHelper, StateUpgrade, StateConfigure, StateRestart,
+	StateProvisioned, StateUpgraded, StateShutdown, StatePlanned, StateDeferred, StateFailed,
+}
+
+// maxTransitions is how many transitions are kept per host, older ones are discarded
+const maxTransitions = 50
+
+// Transition records when a host entered a state
+type Transition struct {
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+// stateMachine tracks the state of a host, it has its own lock so the state can be read while the host is being provisioned
+type stateMachine struct {
+	transitions []Transition
+	observer    func(identity string, state string)
+	mu          sync.Mutex
+}
+
+// State is the current state of the host and when it was entered
+func (h *Host) State() (string, time.Time) {
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+
+	if len(h.state.transitions) == 0 {
+		return StateQueued, h.discovered
+	}
+
+	last := h.state.transitions[len(h.state.transitions)-1]
+
+	return last.State, last.Time
+}
+
+// Transitions are the most recent states the host was in, oldest first
+func (h *Host) Transitions() []Transition {
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+
+	return slices.Clone(h.state.transitions)
+}
+
+// OnTransition sets a function to call whenever the host changes state
+func (h *Host) OnTransition(cb func(identity string, state string)) {
+	h.state.mu.Lock()
+	defer h.state.mu.Unlock()
+
+	h.state.observer = cb
+}
+
+// transition moves the host to state at t
+func (h *Host) transition(state string, t time.Time) {
+	h.state.mu.Lock()
+
+	h.state.transitions = append(h.state.transitions, Transition{State: state, Time: t.UTC()})
+	if len(h.state.transitions) > maxTransitions {
+		h.state.transitions = h.state.transitions[len(h.state.transitions)-maxTransitions:]
+	}
+	observer := h.state.observer
+
+	h.state.mu.Unlock()
+
+	if observer != nil {
+		observer(h.Identity, state)
+	}
+}
+
+// finalState is the state a provisioning attempt that returned err ended in, h.mu should be held
+func (h *Host) finalState(err error) string {
+	switch {
+	case errors.Is(err, ErrDeferred):
+		return StateDeferred
+	case err != nil:
+		return StateFailed
+	case h.plan != nil:
+		return StatePlanned
+	case h.shutdownRequested:
+		return StateShutdown
+	case h.provisioned:
+		return StateProvisioned
+	default:
+		return StateUpgraded
+	}
+}
@@ -31,6 +31,11 @@ var (
 		Name: "choria_provisioner_helper_shutdown_requests",
 		Help: "Host many times the helper asked for a node to be shutdown and it succeeded",
 	}, []string{"site"})
+
+	policyDecisionCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_policy_decisions",
+		Help: "How many admission policy decisions were made by decision",
+	}, []string{"site", "decision"})
 )
 
 func init() {
@@ -39,4 +44,5 @@ func init() {
 	prometheus.MustRegister(rpcErrCtr)
 	prometheus.MustRegister(helperErrCtr)
 	prometheus.MustRegister(helperShutdownCtr)
+	prometheus.MustRegister(policyDecisionCtr)
 }
@@ -0,0 +1,14 @@
+package io.choria.provisioner
+
+decision := "deny" if {
+	endswith(input.identity, ".denied.example.net")
+} else := "allow" if {
+	input.facts.role == "web"
+	"ginkgo.example.net" in input.csr_names
+} else := "defer"
+
+reason := "denied domain" if {
+	endswith(input.identity, ".denied.example.net")
+} else := "web server" if {
+	input.facts.role == "web"
+} else := "unknown role"
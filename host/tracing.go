@@ -0,0 +1,34 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package host
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer follows the globally configured provider, spans are discarded unless tracing is configured
+var tracer = otel.Tracer("github.com/choria-io/provisioner/host")
+
+// spanAttributes identifies the node in spans
+func (h *Host) spanAttributes(extra ...attribute.KeyValue) []attribute.KeyValue {
+	return append([]attribute.KeyValue{
+		attribute.String("choria.identity", h.Identity),
+		attribute.String("choria.site", h.cfg.Site),
+		attribute.Int("choria.attempt", h.Attempt),
+	}, extra...)
+}
+
+// endSpan records err on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/go-choria/aagent"
+	"github.com/choria-io/go-choria/build"
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/lifecycle"
+	"github.com/choria-io/go-choria/protocol"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+	"github.com/choria-io/go-choria/providers/data/ddl"
+	"github.com/choria-io/go-choria/server/agents"
+	"github.com/choria-io/go-choria/statistics"
+	"github.com/choria-io/provisioner/config"
+	"github.com/sirupsen/logrus"
+)
+
+const agentName = "choria_provisioner"
+
+//go:embed choria_provisioner.json
+var agentDDL []byte
+
+type agentPauseRequest struct {
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+}
+
+// AgentDDL is the DDL describing the management agent, clients need it to interact with the agent
+func AgentDDL() []byte {
+	return agentDDL
+}
+
+// agentInfo is the server the management agent is hosted in, it supplies the properties requests are
+// matched against and authorized with, only identity and agent filters can match
+type agentInfo struct {
+	identity    string
+	configFile  string
+	metadata    *agents.Metadata
+	conn        inter.Connector
+	started     time.Time
+	lastMessage time.Time
+	log         *logrus.Entry
+}
+
+func (i *agentInfo) Classes() []string                 { return []string{} }
+func (i *agentInfo) Facts() json.RawMessage            { return json.RawMessage("{}") }
+func (i *agentInfo) Identity() string                  { return i.identity }
+func (i *agentInfo) KnownAgents() []string             { return []string{agentName} }
+func (i *agentInfo) DataFuncMap() (ddl.FuncMap, error) { return ddl.FuncMap{}, nil }
+func (i *agentInfo) BuildInfo() *build.Info            { return &build.Info{} }
+func (i *agentInfo) ConfigFile() string                { return i.configFile }
+func (i *agentInfo) LastProcessedMessage() time.Time   { return i.lastMessage }
+func (i *agentInfo) Provisioning() bool                { return false }
+func (i *agentInfo) StartTime() time.Time              { return i.started }
+func (i *agentInfo) Stats() statistics.ServerStats     { return statistics.ServerStats{} }
+func (i *agentInfo) UpTime() int64                     { return int64(time.Since(i.started).Seconds()) }
+func (i *agentInfo) MachinesStatus() ([]aagent.MachineState, error) {
+	return []aagent.MachineState{}, nil
+}
+
+func (i *agentInfo) AgentMetadata(agent string) (agents.Metadata, bool) {
+	if agent != agentName || i.metadata == nil {
+		return agents.Metadata{}, false
+	}
+
+	return *i.metadata, true
+}
+
+func (i *agentInfo) ConnectedServer() string {
+	if i.conn == nil {
+		return "unknown"
+	}
+
+	return i.conn.ConnectedServer()
+}
+
+func (i *agentInfo) MachineTransition(_ string, _ string, _ string, _ string, _ string) error {
+	return fmt.Errorf("autonomous agents are not supported by the %s agent", agentName)
+}
+
+func (i *agentInfo) NewEvent(_ lifecycle.Type, _ ...lifecycle.Option) error {
+	return fmt.Errorf("lifecycle events are not supported by the %s agent", agentName)
+}
+
+func (i *agentInfo) PrepareForShutdown() error {
+	return fmt.Errorf("shutting down is not supported by the %s agent", agentName)
+}
+
+func (i *agentInfo) matches(req protocol.Request) bool {
+	filter, _ := req.Filter()
+	return filter.MatchServerRequest(req, i, i.log.WithField("request", req.RequestID()))
+}
+
+// newAgent creates the management agent hosted in info, info is used to authorize requests
+func (p *Provisioner) newAgent(info *agentInfo) *mcorpc.Agent {
+	metadata := &agents.Metadata{
+		Name:        agentName,
+		Description: "Choria Provisioner Management",
+		Author:      "R.I.Pienaar <rip@devco.net>",
+		Version:     config.Version,
+		License:     "Apache-2.0",
+		URL:         "https://choria.io",
+		Timeout:     10,
+	}
+
+	agent := mcorpc.New(agentName, metadata, p.fw, p.log)
+	info.metadata = metadata
+	agent.SetServerInfo(info)
+
+	agent.MustRegisterAction("pause", p.agentPauseAction)
+	agent.MustRegisterAction("resume", p.agentResumeAction)
+	agent.MustRegisterAction("status", p.agentStatusAction)
+	agent.MustRegisterAction("hosts", p.agentHostsAction)
+
+	return agent
+}
+
+// startAgent hosts the management agent on conn, requests are accepted on the agent
+// broadcast subject and the node directed subject of the provisioner in every collective
+func (p *Provisioner) startAgent(ctx context.Context, conn inter.Connector) error {
+	info := &agentInfo{
+		identity:   p.fw.Certname(),
+		configFile: p.fw.Configuration().ConfigFile,
+		conn:       conn,
+		started:    time.Now(),
+		log:        p.log,
+	}
+	agent := p.newAgent(info)
+	requests := make(chan inter.ConnectorMessage, 100)
+
+	for _, collective := range p.fw.Configuration().Collectives {
+		for i, target := range []string{conn.AgentBroadcastTarget(collective, agentName), conn.NodeDirectedTarget(collective, info.identity)} {
+			err := conn.QueueSubscribe(ctx, fmt.Sprintf("%s_%s_%d", agentName, collective, i), target, "", requests)
+			if err != nil {
+				return fmt.Errorf("could not subscribe to %s: %s", target, err)
+			}
+		}
+	}
+
+	p.log.Infof("Hosting the %s management agent as %s", agentName, info.identity)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		replies := make(chan *agents.AgentReply, 100)
+
+		for {
+			select {
+			case rawmsg := <-requests:
+				p.handleAgentRequest(ctx, agent, info, conn, rawmsg, replies)
+
+			case reply := <-replies:
+				p.publishAgentReply(conn, reply)
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (p *Provisioner) handleAgentRequest(ctx context.Context, agent *mcorpc.Agent, info *agentInfo, conn inter.Connector, rawmsg inter.ConnectorMessage, replies chan *agents.AgentReply) {
+	transport, err := p.fw.NewTransportFromJSON(rawmsg.Data())
+	if err != nil {
+		p.log.Errorf("Could not decode message into transport: %s", err)
+		return
+	}
+
+	sreq, err := p.fw.NewSecureRequestFromTransport(transport, false)
+	if err != nil {
+		p.log.Errorf("Could not decode incoming request: %s", err)
+		return
+	}
+
+	req, err := p.fw.NewRequestFromSecureRequest(sreq)
+	if err != nil {
+		p.log.Errorf("Could not decode secure request: %s", err)
+		return
+	}
+
+	if req.Agent() != agentName || !info.matches(req) {
+		p.log.Debugf("Skipping message %s that is not for the %s agent or does not match its filter", req.RequestID(), agentName)
+		return
+	}
+
+	msg, err := p.fw.NewMessageFromRequest(req, transport.ReplyTo())
+	if err != nil {
+		p.log.Errorf("Could not create Message: %s", err)
+		return
+	}
+
+	if !msg.ValidateTTL() {
+		p.log.Errorf("Message %s created at %s is too old, TTL is %d", msg.String(), msg.TimeStamp(), msg.TTL())
+		return
+	}
+
+	info.lastMessage = time.Now()
+
+	agent.HandleMessage(ctx, msg, req, conn, replies)
+}
+
+func (p *Provisioner) publishAgentReply(conn inter.Connector, reply *agents.AgentReply) {
+	if reply.Error != nil {
+		p.log.Errorf("Request %s failed, discarding: %s", reply.Message.RequestID(), reply.Error)
+		return
+	}
+
+	msg, err := p.fw.NewMessageFromRequest(reply.Request, reply.Message.ReplyTo())
+	if err != nil {
+		p.log.Errorf("Cannot create reply Message for %s: %s", reply.Message.RequestID(), err)
+		return
+	}
+
+	msg.SetPayload(reply.Body)
+
+	err = conn.Publish(msg)
+	if err != nil {
+		p.log.Errorf("Publishing reply Message for %s failed: %s", reply.Message.RequestID(), err)
+	}
+}
+
+func agentPauseState(reply *mcorpc.Reply, cfg *config.Config) {
+	reply.Data = apiPauseResponse{Paused: cfg.Paused(), PauseInfo: cfg.PauseInfo()}
+}
+
+func agentParsePauseRequest(req *mcorpc.Request, reply *mcorpc.Reply) (*agentPauseRequest, bool) {
+	preq := &agentPauseRequest{}
+	if len(req.Data) > 0 && !mcorpc.ParseRequestData(preq, req, reply) {
+		return nil, false
+	}
+
+	if preq.By == "" {
+		preq.By = fmt.Sprintf("rpc:%s", req.CallerID)
+	}
+
+	return preq, true
+}
+
+func (p *Provisioner) agentPauseAction(_ context.Context, req *mcorpc.Request, reply *mcorpc.Reply, _ *mcorpc.Agent, _ inter.ConnectorInfo) {
+	preq, ok := agentParsePauseRequest(req, reply)
+	if !ok {
+		return
+	}
+
+	cfg := p.currentConfig()
+
+	p.log.Warnf("Pausing provisioning on request of %s via the management agent: %s", preq.By, preq.Reason)
+
+	err := cfg.PauseWithReason(preq.By, preq.Reason)
+	if err != nil {
+		reply.Statuscode = mcorpc.Aborted
+		reply.Statusmsg = err.Error()
+		return
+	}
+
+	agentPauseState(reply, cfg)
+}
+
+func (p *Provisioner) agentResumeAction(_ context.Context, req *mcorpc.Request, reply *mcorpc.Reply, _ *mcorpc.Agent, _ inter.ConnectorInfo) {
+	preq, ok := agentParsePauseRequest(req, reply)
+	if !ok {
+		return
+	}
+
+	cfg := p.currentConfig()
+
+	p.log.Warnf("Resuming provisioning on request of %s via the management agent", preq.By)
+
+	err := cfg.ResumeWithReason(preq.By)
+	if err != nil {
+		reply.Statuscode = mcorpc.Aborted
+		reply.Statusmsg = err.Error()
+		return
+	}
+
+	agentPauseState(reply, cfg)
+}
+
+func (p *Provisioner) agentStatusAction(_ context.Context, _ *mcorpc.Request, reply *mcorpc.Reply, _ *mcorpc.Agent, _ inter.ConnectorInfo) {
+	reply.Data = p.Status()
+}
+
+func (p *Provisioner) agentHostsAction(_ context.Context, _ *mcorpc.Request, reply *mcorpc.Reply, _ *mcorpc.Agent, _ inter.ConnectorInfo) {
+	reply.Data = p.Snapshot()
+}
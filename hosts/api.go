@@ -0,0 +1,215 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/choria-io/provisioner/config"
+)
+
+type apiPauseRequest struct {
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+}
+
+type apiPauseResponse struct {
+	Paused    bool             `json:"paused"`
+	PauseInfo config.PauseInfo `json:"pause_info"`
+}
+
+type apiResult struct {
+	Identity string `json:"identity,omitempty"`
+	Message  string `json:"message"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// RegisterAPI adds the management API to mux, requests must present the management_token as a bearer token
+func (p *Provisioner) RegisterAPI(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/pause", p.apiAuth(p.apiPause))
+	mux.HandleFunc("POST /api/v1/resume", p.apiAuth(p.apiResume))
+	mux.HandleFunc("POST /api/v1/flip", p.apiAuth(p.apiFlip))
+	mux.HandleFunc("GET /api/v1/status", p.apiAuth(p.apiStatus))
+	mux.HandleFunc("GET /api/v1/hosts", p.apiAuth(p.apiHosts))
+	mux.HandleFunc("PUT /api/v1/hosts/{identity}", p.apiAuth(p.apiEnqueue))
+	mux.HandleFunc("DELETE /api/v1/hosts/{identity}", p.apiAuth(p.apiRemove))
+	mux.HandleFunc("POST /api/v1/discover", p.apiAuth(p.apiDiscover))
+	mux.HandleFunc("GET /api/v1/quarantine", p.apiAuth(p.apiQuarantine))
+	mux.HandleFunc("DELETE /api/v1/quarantine/{identity}", p.apiAuth(p.apiRelease))
+}
+
+func (p *Provisioner) apiAuth(next func(http.ResponseWriter, *http.Request, *config.Config)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := p.currentConfig()
+		if cfg.ManagementToken == "" {
+			p.apiRespond(w, http.StatusForbidden, apiError{"management api is disabled, set management_token to enable"})
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.ManagementToken)) != 1 {
+			p.apiRespond(w, http.StatusUnauthorized, apiError{"invalid or missing bearer token"})
+			return
+		}
+
+		next(w, r, cfg)
+	}
+}
+
+func (p *Provisioner) apiRespond(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		p.log.Errorf("Could not write API response: %s", err)
+	}
+}
+
+func (p *Provisioner) apiPauseState(w http.ResponseWriter, cfg *config.Config) {
+	p.apiRespond(w, http.StatusOK, apiPauseResponse{Paused: cfg.Paused(), PauseInfo: cfg.PauseInfo()})
+}
+
+func apiParsePauseRequest(r *http.Request) (*apiPauseRequest, error) {
+	req := &apiPauseRequest{}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 10240))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > 0 {
+		err = json.Unmarshal(body, req)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request: %s", err)
+		}
+	}
+
+	if req.By == "" {
+		req.By = fmt.Sprintf("api:%s", r.RemoteAddr)
+	}
+
+	return req, nil
+}
+
+func (p *Provisioner) apiPause(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	req, err := apiParsePauseRequest(r)
+	if err != nil {
+		p.apiRespond(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+
+	p.log.Warnf("Pausing provisioning on request of %s via the management api: %s", req.By, req.Reason)
+
+	err = cfg.PauseWithReason(req.By, req.Reason)
+	if err != nil {
+		p.apiRespond(w, http.StatusInternalServerError, apiError{err.Error()})
+		return
+	}
+
+	p.apiPauseState(w, cfg)
+}
+
+func (p *Provisioner) apiResume(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	req, err := apiParsePauseRequest(r)
+	if err != nil {
+		p.apiRespond(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+
+	p.log.Warnf("Resuming provisioning on request of %s via the management api", req.By)
+
+	err = cfg.ResumeWithReason(req.By)
+	if err != nil {
+		p.apiRespond(w, http.StatusInternalServerError, apiError{err.Error()})
+		return
+	}
+
+	p.apiPauseState(w, cfg)
+}
+
+func (p *Provisioner) apiFlip(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	if cfg.PauseInfo().Paused {
+		p.apiResume(w, r, cfg)
+	} else {
+		p.apiPause(w, r, cfg)
+	}
+}
+
+func (p *Provisioner) apiStatus(w http.ResponseWriter, _ *http.Request, _ *config.Config) {
+	p.apiRespond(w, http.StatusOK, p.Status())
+}
+
+func (p *Provisioner) apiHosts(w http.ResponseWriter, _ *http.Request, _ *config.Config) {
+	p.apiRespond(w, http.StatusOK, p.Snapshot())
+}
+
+func (p *Provisioner) apiEnqueue(w http.ResponseWriter, r *http.Request, _ *config.Config) {
+	identity := r.PathValue("identity")
+
+	if p.IsQuarantined(identity) {
+		p.apiRespond(w, http.StatusConflict, apiError{fmt.Sprintf("%s is quarantined, release it first", identity)})
+		return
+	}
+
+	owner, owned := p.owner(identity)
+	if !owned {
+		p.apiRespond(w, http.StatusConflict, apiError{fmt.Sprintf("%s is provisioned by %s", identity, owner)})
+		return
+	}
+
+	if !p.Enqueue(identity) {
+		p.apiRespond(w, http.StatusServiceUnavailable, apiError{"could not add to the work queue"})
+		return
+	}
+
+	p.log.Infof("Added %s to the work queue via the management api", identity)
+
+	p.apiRespond(w, http.StatusOK, apiResult{Identity: identity, Message: "added to the work queue"})
+}
+
+func (p *Provisioner) apiRemove(w http.ResponseWriter, r *http.Request, _ *config.Config) {
+	identity := r.PathValue("identity")
+
+	if !p.Remove(identity) {
+		p.apiRespond(w, http.StatusNotFound, apiError{fmt.Sprintf("%s is not known", identity)})
+		return
+	}
+
+	p.log.Infof("Removed %s from the hosts list via the management api", identity)
+
+	p.apiRespond(w, http.StatusOK, apiResult{Identity: identity, Message: "removed from the hosts list"})
+}
+
+func (p *Provisioner) apiDiscover(w http.ResponseWriter, _ *http.Request, _ *config.Config) {
+	p.TriggerDiscovery()
+
+	p.apiRespond(w, http.StatusAccepted, apiResult{Message: "discovery triggered"})
+}
+
+func (p *Provisioner) apiQuarantine(w http.ResponseWriter, _ *http.Request, _ *config.Config) {
+	p.apiRespond(w, http.StatusOK, p.Quarantined())
+}
+
+func (p *Provisioner) apiRelease(w http.ResponseWriter, r *http.Request, _ *config.Config) {
+	identity := r.PathValue("identity")
+
+	if !p.Release(identity) {
+		p.apiRespond(w, http.StatusNotFound, apiError{fmt.Sprintf("%s is not quarantined", identity)})
+		return
+	}
+
+	p.log.Warnf("Released %s from quarantine via the management api", identity)
+
+	p.apiRespond(w, http.StatusOK, apiResult{Identity: identity, Message: "released from quarantine"})
+}
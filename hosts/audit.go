@@ -0,0 +1,169 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/choria-io/provisioner/host"
+)
+
+// Outcomes of a provisioning attempt recorded in the audit log
+const (
+	AuditProvisioned = "provisioned"
+	AuditUpgraded    = "upgraded"
+	AuditShutdown    = "shutdown"
+	AuditPlanned     = "planned"
+	AuditDeferred    = "deferred"
+	AuditFailed      = "failed"
+)
+
+// AuditRecord is a line in the audit log describing one provisioning attempt
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	Identity    string    `json:"identity"`
+	Site        string    `json:"site,omitempty"`
+	Provisioner string    `json:"provisioner,omitempty"`
+	Source      string    `json:"source"`
+	Attempt     int       `json:"attempt"`
+	// Duration is how long the attempt took in seconds
+	Duration float64 `json:"duration"`
+	Outcome  string  `json:"outcome"`
+	Error    string  `json:"error,omitempty"`
+
+	host.Audit
+}
+
+// auditLog appends records to a JSON Lines file
+type auditLog struct {
+	f  *os.File
+	mu sync.Mutex
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{f: f}, nil
+}
+
+// Write appends record as a single line, safe to call on a nil or closed log
+func (a *auditLog) Write(record *AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+
+	j, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+
+	_, err = a.f.Write(append(j, '\n'))
+
+	return err
+}
+
+// Close closes the file, records written after are discarded with an error
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return nil
+	}
+
+	err := a.f.Close()
+	a.f = nil
+
+	return err
+}
+
+// setupAuditLog opens the audit log when one is configured
+func (p *Provisioner) setupAuditLog(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	log, err := openAuditLog(path)
+	if err != nil {
+		return fmt.Errorf("could not open audit log %s: %s", path, err)
+	}
+
+	p.log.Infof("Recording provisioning attempts in audit log %s", path)
+	p.audit = log
+
+	return nil
+}
+
+// recordAudit writes the outcome of a provisioning attempt that started at started to the audit log
+func (p *Provisioner) recordAudit(target *host.Host, started time.Time, err error) {
+	if p.audit == nil {
+		return
+	}
+
+	cfg := p.currentConfig()
+	attempts, _ := target.Attempts()
+
+	record := &AuditRecord{
+		Time:        started.UTC(),
+		Identity:    target.Identity,
+		Site:        cfg.Site,
+		Provisioner: p.member,
+		Source:      "discovery",
+		Attempt:     attempts,
+		Duration:    time.Since(started).Seconds(),
+		Outcome:     auditOutcome(target, err),
+		Audit:       target.Audit(),
+	}
+
+	if target.EventSourced() {
+		record.Source = "event"
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	werr := p.audit.Write(record)
+	if werr != nil {
+		auditErrCtr.WithLabelValues(cfg.Site).Inc()
+		p.log.Errorf("Could not write audit record for %s: %s", target.Identity, werr)
+	}
+}
+
+func auditOutcome(target *host.Host, err error) string {
+	switch {
+	case errors.Is(err, host.ErrDeferred):
+		return AuditDeferred
+	case err != nil:
+		return AuditFailed
+	case target.Planned():
+		return AuditPlanned
+	case target.ShutdownRequested():
+		return AuditShutdown
+	case target.Provisioned():
+		return AuditProvisioned
+	default:
+		return AuditUpgraded
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+	identityfilter "github.com/choria-io/go-choria/filter/identity"
+	"github.com/choria-io/go-choria/protocol"
+	"github.com/choria-io/go-choria/providers/discovery/broadcast"
+	"github.com/choria-io/provisioner/config"
+)
+
+// DiscoverySource finds nodes that are ready to be provisioned
+type DiscoverySource interface {
+	Discover(ctx context.Context, cfg *config.Config) ([]string, error)
+}
+
+// FilterMatcher determines if a node matches the configured discovery filter. Nodes found by their startup
+// events or by sources that are not matchers themselves are checked before they are provisioned while nodes
+// found by a matcher are assumed to match.
+type FilterMatcher interface {
+	Matches(ctx context.Context, cfg *config.Config, identity string) (bool, error)
+}
+
+// NamedSource is a DiscoverySource with a name used in logs and metrics
+type NamedSource interface {
+	Name() string
+}
+
+func sourceName(source DiscoverySource, i int) string {
+	named, ok := source.(NamedSource)
+	if ok {
+		return named.Name()
+	}
+
+	return fmt.Sprintf("source_%d", i+1)
+}
+
+// Clock supplies the current time
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// broadcastDiscovery finds nodes in the provisioning collective that have the choria_provision agent and match the discovery filter
+type broadcastDiscovery struct {
+	fw   *choria.Framework
+	name string
+}
+
+func (d *broadcastDiscovery) Name() string { return d.name }
+
+func (d *broadcastDiscovery) Discover(ctx context.Context, cfg *config.Config) ([]string, error) {
+	f, err := cfg.DiscoveryFilter.Filter("choria_provision")
+	if err != nil {
+		return nil, err
+	}
+
+	return d.discover(ctx, cfg, f)
+}
+
+func (d *broadcastDiscovery) Matches(ctx context.Context, cfg *config.Config, identity string) (bool, error) {
+	// identity filters match when any of them match so the configured ones are checked here and the node is discovered by its identity
+	if len(cfg.DiscoveryFilter.Identities) > 0 && !identityfilter.Match(cfg.DiscoveryFilter.Identities, identity) {
+		return false, nil
+	}
+
+	if len(cfg.DiscoveryFilter.Facts) == 0 && len(cfg.DiscoveryFilter.Classes) == 0 && cfg.DiscoveryFilter.Compound == "" {
+		return true, nil
+	}
+
+	df := cfg.DiscoveryFilter
+	df.Identities = []string{identity}
+
+	f, err := df.Filter("choria_provision")
+	if err != nil {
+		return false, err
+	}
+
+	nodes, err := d.discover(ctx, cfg, f)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(nodes, identity), nil
+}
+
+func (d *broadcastDiscovery) discover(ctx context.Context, cfg *config.Config, f *protocol.Filter) ([]string, error) {
+	bd := broadcast.New(d.fw)
+	return bd.Discover(ctx, broadcast.Collective(cfg.Collective), broadcast.Filter(f), broadcast.SlidingWindow(), broadcast.Timeout(cfg.Timeouts.DiscoveryDuration))
+}
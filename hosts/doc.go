@@ -0,0 +1,20 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package hosts discovers nodes in provisioning mode and provisions them.
+//
+// A Provisioner can be embedded in other services:
+//
+//	prov, err := hosts.New(
+//		hosts.WithFramework(fw),
+//		hosts.WithConfig(cfg),
+//		hosts.OnProvisioned(func(identity string) { ... }),
+//		hosts.OnFailed(func(identity string, err error) { ... }),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//
+//	err = prov.Run(ctx)
+package hosts
@@ -0,0 +1,79 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// drain waits up to timeouts.drain for workers to finish the nodes they are provisioning once discovery and events
+// stopped, provisions that did not finish are canceled and the identities that were abandoned are returned
+func (p *Provisioner) drain(cancelWork context.CancelFunc) []string {
+	defer cancelWork()
+
+	cfg := p.currentConfig()
+	grace := cfg.Timeouts.DrainDuration
+
+	busy := p.busyIdentities()
+	if len(busy) > 0 {
+		p.log.Warnf("Waiting up to %v for %d nodes to finish provisioning: %s", grace, len(busy), strings.Join(busy, ", "))
+	}
+
+	queued := p.work.Len()
+	if queued > 0 {
+		p.log.Infof("Leaving %d nodes in the work queue", queued)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(finished)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+		p.log.Infof("All workers finished")
+		return nil
+
+	case <-timer.C:
+	}
+
+	abandoned := p.busyIdentities()
+	if len(abandoned) > 0 {
+		abandonedCtr.WithLabelValues(cfg.Site).Add(float64(len(abandoned)))
+		p.log.Errorf("Abandoning %d nodes that did not finish provisioning within %v: %s", len(abandoned), grace, strings.Join(abandoned, ", "))
+	}
+
+	cancelWork()
+
+	// canceled provisions should stop promptly, this avoids hanging on those that do not
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		p.log.Warnf("Workers did not exit after canceling provisions")
+	}
+
+	return abandoned
+}
+
+// busyIdentities lists the nodes being provisioned
+func (p *Provisioner) busyIdentities() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	busy := []string{}
+	for identity := range p.busy {
+		busy = append(busy, identity)
+	}
+	slices.Sort(busy)
+
+	return busy
+}
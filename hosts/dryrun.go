@@ -0,0 +1,24 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"github.com/choria-io/provisioner/host"
+)
+
+// markPlanned remembers a node planned in dry run mode, it stays in provisioning mode and would otherwise be
+// planned again, with new certificates and server JWTs issued, every time it is discovered
+func (p *Provisioner) markPlanned(target *host.Host) {
+	p.mu.Lock()
+	p.planned[target.Identity] = p.clock.Now()
+	p.mu.Unlock()
+}
+
+// clearPlanned forgets all nodes planned in dry run mode so they are planned again when found
+func (p *Provisioner) clearPlanned() {
+	p.mu.Lock()
+	clear(p.planned)
+	p.mu.Unlock()
+}
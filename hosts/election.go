@@ -6,36 +6,49 @@ package hosts
 
 import (
 	"context"
-	"sync"
 
 	"github.com/choria-io/go-choria/backoff"
 	"github.com/choria-io/go-choria/inter"
 	election "github.com/choria-io/go-choria/providers/election/streams"
-	"github.com/sirupsen/logrus"
 )
 
-func startElection(ctx context.Context, wg *sync.WaitGroup, conn inter.Connector, fw inter.Framework, trigger chan struct{}, logger *logrus.Entry) error {
-	defer wg.Done()
+func (p *Provisioner) startElection(ctx context.Context, conn inter.Connector) error {
+	defer p.wg.Done()
 
-	log := logger.WithField("election", "provisioner")
+	log := p.log.WithField("election", "provisioner")
 	log.Infof("Starting leader election against 'provisioner'")
-	conf.Pause()
+	p.currentConfig().SetStandby(true)
 
 	won := func() {
-		conf.Resume()
+		cfg := p.currentConfig()
+
+		// another instance might have been paused while we were standing by
+		err := cfg.RestorePauseState()
+		if err != nil {
+			log.Errorf("Could not restore pause state: %s", err)
+		}
+
+		cfg.SetStandby(false)
+		p.setLeader(true)
 		log.Warn("Became leader after winning election")
 
-		trigger <- struct{}{}
+		err = p.restoreQueue()
+		if err != nil {
+			log.Errorf("Could not restore the work queue: %s", err)
+		}
+
+		p.TriggerDiscovery()
 		log.Info("Triggered a discovery after becoming leader")
 	}
 
 	lost := func() {
-		conf.Pause()
+		p.currentConfig().SetStandby(true)
+		p.setLeader(false)
 		log.Warn("Lost leadership")
-		removeAllHosts()
+		p.removeAllHosts()
 	}
 
-	elect, err := fw.NewElection(ctx, conn, "provisioner", true, election.OnWon(won), election.OnLost(lost), election.WithBackoff(backoff.TwentySec), election.WithDebug(log.Debugf))
+	elect, err := p.fw.NewElection(ctx, conn, "provisioner", true, election.OnWon(won), election.OnLost(lost), election.WithBackoff(backoff.TwentySec), election.WithDebug(log.Debugf))
 	if err != nil {
 		return err
 	}
@@ -7,50 +7,45 @@ package hosts
 import (
 	"context"
 	"fmt"
-	"sync"
+	"slices"
 
 	"github.com/choria-io/go-choria/inter"
 	"github.com/choria-io/go-choria/lifecycle"
-
-	"github.com/choria-io/provisioner/host"
 )
 
-func connect(ctx context.Context) (inter.Connector, error) {
+func (p *Provisioner) connect(ctx context.Context) (inter.Connector, error) {
 	if ctx.Err() != nil {
 		return nil, fmt.Errorf("exiting on shut down")
 	}
 
-	return fw.NewConnector(ctx, fw.MiddlewareServers, fw.Certname(), log)
+	return p.fw.NewConnector(ctx, p.fw.MiddlewareServers, p.fw.Certname(), p.log)
 }
 
-func listen(ctx context.Context, wg *sync.WaitGroup, component string, conn inter.Connector) {
-	defer wg.Done()
+func (p *Provisioner) listen(ctx context.Context, components []string, conn inter.Connector) {
+	defer p.wg.Done()
 
 	events := make(chan inter.ConnectorMessage, 1000)
 
-	rid, err := fw.NewRequestID()
-	if err != nil {
-		log.Errorf("Could not create provisioning data listener unique id: %s", err)
-		return
-	}
+	for _, component := range components {
+		rid, err := p.fw.NewRequestID()
+		if err != nil {
+			p.log.Errorf("Could not create provisioning data listener unique id: %s", err)
+			return
+		}
 
-	err = conn.QueueSubscribe(ctx, rid, fmt.Sprintf("choria.lifecycle.event.startup.%s", component), "", events)
-	if err != nil {
-		log.Errorf("Could not listen for lifecycle events: %s", err)
-		return
+		err = conn.QueueSubscribe(ctx, rid, fmt.Sprintf("choria.lifecycle.event.*.%s", component), "", events)
+		if err != nil {
+			p.log.Errorf("Could not listen for %s lifecycle events: %s", component, err)
+			return
+		}
 	}
 
 	for {
 		select {
 		case e := <-events:
-			node, err := handle(e)
+			err := p.handle(e)
 			if err != nil {
-				log.Errorf("could not handle message: %s", err)
-			}
-
-			if node != "" && add(host.NewHost(node, conf)) {
-				log.Infof("Adding %s to the provision list after receiving an event", node)
-				eventsCtr.WithLabelValues(conf.Site).Inc()
+				p.log.Errorf("could not handle message: %s", err)
 			}
 
 		case <-ctx.Done():
@@ -59,24 +54,46 @@ func listen(ctx context.Context, wg *sync.WaitGroup, component string, conn inte
 	}
 }
 
-func handle(msg inter.ConnectorMessage) (string, error) {
-	if conf.Paused() {
-		log.Warnf("Skipping event processing while paused")
-		return "", nil
+func (p *Provisioner) handle(msg inter.ConnectorMessage) error {
+	if p.currentConfig().Paused() {
+		p.log.Warnf("Skipping event processing while paused")
+		return nil
 	}
 
 	event, err := lifecycle.NewFromJSON(msg.Data())
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return handleEvent(event)
+	p.handleEvent(event)
+
+	return nil
 }
 
-func handleEvent(event lifecycle.Event) (string, error) {
-	if event.Type() != lifecycle.Startup {
-		return "", nil
+// handleEvent adds nodes that started or are alive in provisioning mode and removes queued nodes that shut down
+func (p *Provisioner) handleEvent(event lifecycle.Event) {
+	cfg := p.currentConfig()
+	node := event.Identity()
+
+	if !slices.Contains(cfg.LifecycleEvents, event.TypeString()) || node == "" {
+		return
 	}
 
-	return event.Identity(), nil
+	lifecycleEventsCtr.WithLabelValues(cfg.Site, event.TypeString()).Inc()
+
+	switch event.Type() {
+	case lifecycle.Startup, lifecycle.Alive:
+		h := p.newHost(node)
+		h.MarkEventSourced()
+
+		if p.add(h) {
+			p.log.Infof("Adding %s to the provision list after receiving a %s event", node, event.TypeString())
+			eventsCtr.WithLabelValues(cfg.Site).Inc()
+		}
+
+	case lifecycle.Shutdown:
+		if p.drop(node) {
+			p.log.Infof("Removing %s from the provision list after receiving a shutdown event", node)
+		}
+	}
 }
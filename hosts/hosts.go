@@ -6,7 +6,11 @@ package hosts
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,88 +18,497 @@ import (
 	"github.com/choria-io/go-choria/lifecycle"
 
 	"github.com/choria-io/go-choria/choria"
-	"github.com/choria-io/go-choria/client/client"
-	"github.com/choria-io/go-choria/providers/discovery/broadcast"
 	"github.com/choria-io/provisioner/config"
 	"github.com/choria-io/provisioner/host"
 	"github.com/sirupsen/logrus"
 )
 
-var (
-	hosts = make(map[string]*host.Host)
-	work  = make(chan *host.Host, 50000)
-	done  = make(chan *host.Host, 50000)
-	mu    = &sync.Mutex{}
+// Provisioner discovers nodes in provisioning mode and provisions them using a pool of workers
+type Provisioner struct {
+	hosts map[string]*host.Host
+	work  *workQueue
+	done  chan *host.Host
+	mu    *sync.Mutex
 	log   *logrus.Entry
 	fw    *choria.Framework
 	conf  *config.Config
-	wg    = &sync.WaitGroup{}
-)
+	cmu   *sync.Mutex
+	wg    *sync.WaitGroup
+
+	busy          map[string]time.Time
+	retrying      map[string]time.Time
+	failures      map[string]*failureHistory
+	quarantined   map[string]*QuarantineEntry
+	planned       map[string]time.Time
+	leader        bool
+	lastDiscovery time.Time
+
+	member       string
+	members      MembershipStore
+	ring         *shardRing
+	shardMembers []string
+	locks        LockStore
+	audit        *auditLog
+	publisher    EventPublisher
+	webhooks     *webhookSender
+
+	sources []DiscoverySource
+	matcher FilterMatcher
+	queue   QueueStore
+	// quarantine persists the quarantined nodes when set
+	quarantine QuarantineStore
+	// queueWrites are changes waiting to be written to queue
+	queueWrites *queueWriter
+	clock       Clock
+	hooks       hooks
+
+	reconfigured    chan struct{}
+	discoverTrigger chan struct{}
+	statesChanged   chan struct{}
+}
+
+// HostInfo describes a host known to the provisioner
+type HostInfo struct {
+	Identity     string            `json:"identity"`
+	Discovered   time.Time         `json:"discovered"`
+	Provisioning bool              `json:"provisioning"`
+	Started      time.Time         `json:"started,omitempty"`
+	Source       string            `json:"source"`
+	Priority     *int              `json:"priority,omitempty"`
+	Attempts     int               `json:"attempts"`
+	LastError    string            `json:"last_error,omitempty"`
+	RetryAt      time.Time         `json:"retry_at,omitempty"`
+	State        string            `json:"state"`
+	StateSince   time.Time         `json:"state_since"`
+	Transitions  []host.Transition `json:"transitions"`
+}
+
+// Snapshot is the current state of the hosts list and work queue
+type Snapshot struct {
+	Hosts     []HostInfo `json:"hosts"`
+	WorkQueue int        `json:"work_queue"`
+	Busy      int        `json:"busy"`
+}
+
+// Status is a summary of the state of this provisioner instance
+type Status struct {
+	Identity       string           `json:"identity"`
+	Site           string           `json:"site"`
+	Version        string           `json:"version"`
+	Paused         bool             `json:"paused"`
+	PauseInfo      config.PauseInfo `json:"pause_info"`
+	Maintenance    string           `json:"maintenance_window,omitempty"`
+	MaintenanceEnd time.Time        `json:"maintenance_window_ends,omitempty"`
+	LeaderElection bool             `json:"leader_election"`
+	Leader         bool             `json:"leader"`
+	Sharding       bool             `json:"sharding"`
+	Members        []string         `json:"members,omitempty"`
+	KnownHosts     int              `json:"known_hosts"`
+	Quarantined    int              `json:"quarantined"`
+	Planned        int              `json:"planned"`
+	WorkQueue      int              `json:"work_queue"`
+	Busy           int              `json:"busy"`
+	Workers        int              `json:"workers"`
+	LastDiscovery  time.Time        `json:"last_discovery,omitempty"`
+}
+
+// New creates a Provisioner, a configuration is required and a framework is required to Run it
+func New(opts ...Option) (*Provisioner, error) {
+	p := &Provisioner{
+		hosts:           make(map[string]*host.Host),
+		done:            make(chan *host.Host, 50000),
+		mu:              &sync.Mutex{},
+		cmu:             &sync.Mutex{},
+		wg:              &sync.WaitGroup{},
+		busy:            make(map[string]time.Time),
+		retrying:        make(map[string]time.Time),
+		failures:        make(map[string]*failureHistory),
+		quarantined:     make(map[string]*QuarantineEntry),
+		planned:         make(map[string]time.Time),
+		clock:           realClock{},
+		reconfigured:    make(chan struct{}, 1),
+		discoverTrigger: make(chan struct{}, 1),
+		statesChanged:   make(chan struct{}, 1),
+		queueWrites:     newQueueWriter(),
+	}
+
+	for _, opt := range opts {
+		err := opt(p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.conf == nil {
+		return nil, fmt.Errorf("configuration is required")
+	}
+
+	p.work = newWorkQueue(50000, p.conf)
+
+	if p.log == nil {
+		if p.fw != nil {
+			p.log = p.fw.Logger("hosts")
+		} else {
+			logger := logrus.New()
+			logger.Out = io.Discard
+			p.log = logrus.NewEntry(logger)
+		}
+	}
+
+	for _, source := range p.sources {
+		matcher, ok := source.(FilterMatcher)
+		if ok {
+			p.matcher = matcher
+			break
+		}
+	}
+
+	return p, nil
+}
+
+// Run starts the provisioning process and blocks until ctx is canceled
+func (p *Provisioner) Run(ctx context.Context) error {
+	if p.fw == nil {
+		return fmt.Errorf("a choria framework is required")
+	}
 
-// Process starts the provisioning process
-func Process(ctx context.Context, cfg *config.Config, cfw *choria.Framework) error {
-	fw = cfw
-	conf = cfg
-	log = fw.Logger("hosts")
+	cfg := p.currentConfig()
+
+	p.log.Infof("Choria Provisioner starting using configuration file %s. Discovery interval %s using %d workers", cfg.File, cfg.Interval, cfg.Workers)
+
+	if cfg.DryRun {
+		p.log.Warnf("Running in dry run mode, nodes will not be configured, restarted, shut down or upgraded")
+	}
 
-	log.Infof("Choria Provisioner starting using configuration file %s. Discovery interval %s using %d workers", conf.File, conf.Interval, conf.Workers)
+	err := p.setupAuditLog(cfg.AuditLog)
+	if err != nil {
+		return err
+	}
+	defer p.audit.Close()
 
-	conn, err := connect(ctx)
+	err = p.setupWebhooks(cfg)
+	if err != nil {
+		return err
+	}
+
+	conn, err := p.connect(ctx)
 	if err != nil {
 		return fmt.Errorf("could not create initial events connection: %s", err)
 	}
 
-	err = publishStartupEvent(conn)
+	err = p.publishStartupEvent(conn)
+	if err != nil {
+		p.log.Errorf("Could not publish startup event: %s", err)
+	}
+
+	if p.publisher == nil {
+		p.publisher = conn
+	}
+
+	err = p.setupPauseStore(ctx, conn, cfg)
+	if err != nil {
+		return fmt.Errorf("could not restore pause state: %s", err)
+	}
+
+	if len(p.sources) == 0 {
+		p.sources, err = p.newDiscoverySources(ctx, conn, cfg)
+		if err != nil {
+			return fmt.Errorf("could not set up discovery: %s", err)
+		}
+	}
+
+	if p.matcher == nil {
+		p.matcher = &broadcastDiscovery{fw: p.fw, name: config.DiscoveryBroadcast}
+	}
+
+	err = p.setupSharding(ctx, conn, cfg)
+	if err != nil {
+		return fmt.Errorf("could not join the shard members: %s", err)
+	}
+
+	err = p.setupLocks(ctx, conn, cfg)
 	if err != nil {
-		log.Errorf("Could not publish startup event: %s", err)
+		return fmt.Errorf("could not set up node locks: %s", err)
 	}
 
-	discoverTrigger := make(chan struct{}, 1)
+	err = p.setupQueueStore(ctx, conn, cfg)
+	if err != nil {
+		return fmt.Errorf("could not set up the work queue: %s", err)
+	}
 
-	if conf.LeaderElection {
-		conf.Pause()
+	err = p.setupQuarantineStore(ctx, conn, cfg)
+	if err != nil {
+		return fmt.Errorf("could not set up the quarantine: %s", err)
+	}
 
-		wg.Add(1)
-		go startElection(ctx, wg, conn, fw, discoverTrigger, log)
+	// with leader election the queue is restored once this instance becomes leader
+	if !cfg.LeaderElection {
+		err = p.restoreQueue()
+		if err != nil {
+			p.log.Errorf("Could not restore the work queue: %s", err)
+		}
 	}
 
-	wg.Add(1)
-	go listen(ctx, wg, cfg.LifecycleComponent, conn)
+	if cfg.ManagementAgent {
+		err = p.startAgent(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("could not start the management agent: %s", err)
+		}
+	}
+
+	if cfg.LeaderElection {
+		cfg.SetStandby(true)
+
+		p.wg.Add(1)
+		go p.startElection(ctx, conn)
+	}
+
+	p.wg.Add(1)
+	go p.watchMaintenance(ctx)
+
+	p.wg.Add(1)
+	go p.listen(ctx, cfg.LifecycleComponents, conn)
+
+	p.wg.Add(1)
+	go p.finisher(ctx)
+
+	p.wg.Add(1)
+	go p.watchStates(ctx)
+
+	// provisions in progress are not interrupted by ctx so they can finish while draining
+	work, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	// webhooks are posted until draining finished, what is left is delivered after draining
+	if p.webhooks != nil {
+		p.webhooks.start(context.WithoutCancel(ctx))
+	}
 
-	wg.Add(1)
-	go finisher(ctx, wg)
+	// the durable queue is written until draining finished, drain writes what is left
+	go p.writeQueue(work)
 
 	for i := 0; i < cfg.Workers; i++ {
-		wg.Add(1)
-		go provisioner(ctx, wg, i+1)
+		p.wg.Add(1)
+		go p.provisioner(ctx, work, i+1)
 	}
 
 	timer := time.NewTicker(cfg.IntervalDuration)
 
-	discoveredCtr.WithLabelValues(conf.Site).Add(0.0)
-	provisionedCtr.WithLabelValues(conf.Site).Add(0.0)
-	waitingGauge.WithLabelValues(conf.Site).Set(0.0)
-	unprovisionedGauge.WithLabelValues(conf.Site).Set(0.0)
+	discoveredCtr.WithLabelValues(cfg.Site).Add(0.0)
+	provisionedCtr.WithLabelValues(cfg.Site).Add(0.0)
+	waitingGauge.WithLabelValues(cfg.Site).Set(0.0)
+	quarantinedGauge.WithLabelValues(cfg.Site).Set(float64(len(p.Quarantined())))
+	unprovisionedGauge.WithLabelValues(cfg.Site).Set(0.0)
+	p.updateStates()
 
-	discover(ctx)
+	p.discover(ctx)
 
 	for {
 		select {
 		case <-timer.C:
-			discover(ctx)
+			p.discover(ctx)
 
-		case <-discoverTrigger:
-			discover(ctx)
+		case <-p.discoverTrigger:
+			p.discover(ctx)
+
+		case <-p.reconfigured:
+			interval := p.currentConfig().IntervalDuration
+			p.log.Infof("Resetting discovery interval to %v after reconfiguration", interval)
+			timer.Reset(interval)
 
 		case <-ctx.Done():
-			log.Infof("Existing on context interrupt")
+			p.log.Infof("Existing on context interrupt")
+			p.drain(cancelWork)
+			p.flushQueue()
+			p.flushWebhooks()
 			return nil
 		}
 	}
 }
 
-func publishStartupEvent(conn inter.Connector) error {
-	event, err := lifecycle.New(lifecycle.Startup, lifecycle.Component("provisioner"), lifecycle.Identity(fw.Config.Identity), lifecycle.Version(config.Version))
+// Reconfigure replaces the active configuration, hosts already being provisioned will
+// complete using the configuration they started with while new provisions use cfg
+func (p *Provisioner) Reconfigure(cfg *config.Config) {
+	p.setConfig(cfg)
+	p.work.Reorder(cfg)
+
+	// a changed helper or policy should be planned against every node again
+	p.clearPlanned()
+
+	select {
+	case p.reconfigured <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Provisioner) setConfig(cfg *config.Config) {
+	p.cmu.Lock()
+	p.conf = cfg
+	p.cmu.Unlock()
+}
+
+func (p *Provisioner) currentConfig() *config.Config {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+
+	return p.conf
+}
+
+// TriggerDiscovery requests an immediate discovery
+func (p *Provisioner) TriggerDiscovery() {
+	select {
+	case p.discoverTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue adds identity to the work queue replacing any existing entry, nodes already planned in dry run mode are planned
+// again, returns false if the queue is full, identity is quarantined or provisioned by another member
+func (p *Provisioner) Enqueue(identity string) bool {
+	h := p.newHost(identity)
+	h.MarkVetted()
+
+	p.mu.Lock()
+	delete(p.planned, identity)
+	_, known := p.hosts[identity]
+	if known {
+		p.removeUnlocked(h)
+	}
+	p.mu.Unlock()
+
+	return p.add(h)
+}
+
+// Remove removes identity from the hosts list, a queued entry will be skipped by workers, returns false if it was not known
+func (p *Provisioner) Remove(identity string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, known := p.hosts[identity]
+	if !known {
+		return false
+	}
+
+	p.removeUnlocked(h)
+
+	return true
+}
+
+// Snapshot reports on the hosts known to the provisioner sorted by discovery time
+func (p *Provisioner) Snapshot() *Snapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snap := &Snapshot{
+		Hosts:     []HostInfo{},
+		WorkQueue: p.work.Len(),
+		Busy:      len(p.busy),
+	}
+
+	for _, h := range p.hosts {
+		started, provisioning := p.busy[h.Identity]
+		info := HostInfo{
+			Identity:     h.Identity,
+			Discovered:   h.DiscoveredTime(),
+			Provisioning: provisioning,
+			Started:      started,
+			Source:       "discovery",
+			RetryAt:      p.retrying[h.Identity],
+		}
+
+		info.Attempts, info.LastError = h.Attempts()
+		info.State, info.StateSince = h.State()
+		info.Transitions = h.Transitions()
+
+		if h.EventSourced() {
+			info.Source = "event"
+		}
+
+		priority, known := h.Priority()
+		if known {
+			info.Priority = &priority
+		}
+
+		snap.Hosts = append(snap.Hosts, info)
+	}
+
+	sort.Slice(snap.Hosts, func(i, j int) bool {
+		if snap.Hosts[i].Discovered.Equal(snap.Hosts[j].Discovered) {
+			return snap.Hosts[i].Identity < snap.Hosts[j].Identity
+		}
+
+		return snap.Hosts[i].Discovered.Before(snap.Hosts[j].Discovered)
+	})
+
+	return snap
+}
+
+// Status reports on the state of this provisioner instance
+func (p *Provisioner) Status() *Status {
+	cfg := p.currentConfig()
+
+	status := &Status{
+		Site:           cfg.Site,
+		Version:        config.Version,
+		Paused:         cfg.Paused(),
+		PauseInfo:      cfg.PauseInfo(),
+		LeaderElection: cfg.LeaderElection,
+		Sharding:       cfg.Sharding.Enabled(),
+		Workers:        cfg.Workers,
+	}
+
+	status.Maintenance, status.MaintenanceEnd = cfg.MaintenanceState()
+
+	if p.fw != nil {
+		status.Identity = p.fw.Certname()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status.Leader = p.leader || !cfg.LeaderElection
+	status.Members = slices.Clone(p.shardMembers)
+	status.KnownHosts = len(p.hosts)
+	status.Quarantined = len(p.quarantined)
+	status.Planned = len(p.planned)
+	status.WorkQueue = p.work.Len()
+	status.Busy = len(p.busy)
+	status.LastDiscovery = p.lastDiscovery
+
+	return status
+}
+
+func (p *Provisioner) newHost(identity string) *host.Host {
+	return p.newHostAt(identity, p.clock.Now())
+}
+
+// newHostAt creates a host discovered at discovered that measures how long it waited using the provisioner clock
+func (p *Provisioner) newHostAt(identity string, discovered time.Time) *host.Host {
+	h := host.NewHostAt(identity, p.currentConfig(), discovered)
+	h.UseClock(p.clock.Now)
+
+	return h
+}
+
+func (p *Provisioner) setLeader(isLeader bool) {
+	p.mu.Lock()
+	p.leader = isLeader
+	p.mu.Unlock()
+}
+
+func (p *Provisioner) setBusy(h *host.Host, isBusy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if isBusy {
+		p.busy[h.Identity] = p.clock.Now()
+	} else {
+		delete(p.busy, h.Identity)
+	}
+}
+
+func (p *Provisioner) publishStartupEvent(conn inter.Connector) error {
+	event, err := lifecycle.New(lifecycle.Startup, lifecycle.Component("provisioner"), lifecycle.Identity(p.fw.Config.Identity), lifecycle.Version(config.Version))
 	if err != nil {
 		return fmt.Errorf("could not create event: %s", err)
 	}
@@ -108,116 +521,208 @@ func publishStartupEvent(conn inter.Connector) error {
 	return nil
 }
 
-func remove(host *host.Host) {
-	mu.Lock()
-	defer mu.Unlock()
+func (p *Provisioner) remove(host *host.Host) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	removeUnlocked(host)
+	p.removeUnlocked(host)
 }
 
-func removeUnlocked(host *host.Host) {
-	delete(hosts, host.Identity)
-	unprovisionedGauge.WithLabelValues(conf.Site).Set(float64(len(hosts)))
+func (p *Provisioner) removeUnlocked(host *host.Host) {
+	delete(p.hosts, host.Identity)
+	delete(p.retrying, host.Identity)
+	p.work.Remove(host.Identity)
+	p.forget(host)
+	unprovisionedGauge.WithLabelValues(p.currentConfig().Site).Set(float64(len(p.hosts)))
 
-	waitingGauge.WithLabelValues(conf.Site).Set(float64(len(work)))
+	waitingGauge.WithLabelValues(p.currentConfig().Site).Set(float64(p.work.Len()))
+	p.stateChanged()
 }
 
-func removeAllHosts() {
-	mu.Lock()
-	defer mu.Unlock()
+// drop removes a node that went away, nodes being provisioned are kept as they restart while being provisioned, returns false if it was not removed
+func (p *Provisioner) drop(identity string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	for _, h := range hosts {
-		delete(hosts, h.Identity)
+	h, known := p.hosts[identity]
+	if !known {
+		return false
 	}
 
-	for {
-		select {
-		case <-work:
-		default:
-			unprovisionedGauge.WithLabelValues(conf.Site).Set(float64(len(hosts)))
-			waitingGauge.WithLabelValues(conf.Site).Set(float64(len(work)))
+	_, busy := p.busy[identity]
+	if busy {
+		return false
+	}
 
-			return
-		}
+	p.removeUnlocked(h)
+
+	return true
+}
+
+func (p *Provisioner) removeAllHosts() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, h := range p.hosts {
+		delete(p.hosts, h.Identity)
+		delete(p.retrying, h.Identity)
 	}
+
+	p.work.Clear()
+
+	unprovisionedGauge.WithLabelValues(p.currentConfig().Site).Set(float64(len(p.hosts)))
+	waitingGauge.WithLabelValues(p.currentConfig().Site).Set(float64(p.work.Len()))
+	p.stateChanged()
 }
 
-func isCurrent(h *host.Host) bool {
-	mu.Lock()
-	defer mu.Unlock()
+func (p *Provisioner) isCurrent(h *host.Host) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	_, ok := hosts[h.Identity]
+	_, ok := p.hosts[h.Identity]
 	return ok
 }
 
-func add(host *host.Host) bool {
-	mu.Lock()
-	defer mu.Unlock()
+func (p *Provisioner) add(host *host.Host) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, quarantined := p.quarantined[host.Identity]
+	if quarantined {
+		p.log.Debugf("Skipping quarantined node %s", host.Identity)
+		return false
+	}
 
-	_, known := hosts[host.Identity]
+	_, planned := p.planned[host.Identity]
+	if planned {
+		p.log.Debugf("Skipping %s, it was already planned in dry run mode", host.Identity)
+		return false
+	}
+
+	if !p.ownsUnlocked(host.Identity) {
+		p.log.Debugf("Skipping %s, it is provisioned by another member", host.Identity)
+		return false
+	}
+
+	_, known := p.hosts[host.Identity]
 	if known {
+		// a failed host waiting to be retried will be provisioned again soon
+		_, retrying := p.retrying[host.Identity]
+		if retrying {
+			return false
+		}
+
 		// if it was recently added don't add it again else we remove it
 		// and add it again, might cause a dupe provision but better than
 		// nodes dying on us, a dupe provision will time out on first rpc
 		// failure and provision will also not provision nodes added too
 		// long ago
-		if time.Since(host.DiscoveredTime()) < conf.IntervalDuration {
+		if p.clock.Now().Sub(host.DiscoveredTime()) < p.currentConfig().IntervalDuration {
 			return false
 		}
-		removeUnlocked(host)
+		p.removeUnlocked(host)
 	}
 
-	log.Infof("Adding %s to the work queue with %d entries", host.Identity, len(hosts))
-	hosts[host.Identity] = host
+	p.log.Infof("Adding %s to the work queue with %d entries", host.Identity, len(p.hosts))
+	p.hosts[host.Identity] = host
+	host.OnTransition(func(string, string) { p.stateChanged() })
 
-	select {
-	case work <- host:
-	default:
-		log.Warnf("Adding host to work queue failed with %d / %d entries", len(work), cap(work))
-		removeUnlocked(host)
+	if p.work.Push(host) {
+		p.persist(host)
+	} else {
+		p.log.Warnf("Adding host to work queue failed with %d / %d entries", p.work.Len(), p.work.Cap())
+		p.removeUnlocked(host)
 	}
 
-	unprovisionedGauge.WithLabelValues(conf.Site).Set(float64(len(hosts)))
-	waitingGauge.WithLabelValues(conf.Site).Set(float64(len(work)))
+	unprovisionedGauge.WithLabelValues(p.currentConfig().Site).Set(float64(len(p.hosts)))
+	waitingGauge.WithLabelValues(p.currentConfig().Site).Set(float64(p.work.Len()))
+
+	p.publishEvent(host.Identity, EventDiscovered, "")
+	p.stateChanged()
 
 	return true
 }
 
-func discover(ctx context.Context) {
-	if conf.Paused() {
-		log.Warnf("Skipping discovery while paused")
+// requeue adds a host that was taken from the work queue back to it unless it was removed from the hosts list meanwhile
+func (p *Provisioner) requeue(host *host.Host) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.hosts[host.Identity] != host {
+		return
+	}
+
+	if p.work.Push(host) {
+		p.persist(host)
+	} else {
+		p.log.Warnf("Returning %s to the work queue failed with %d / %d entries", host.Identity, p.work.Len(), p.work.Cap())
+		p.removeUnlocked(host)
+	}
+
+	waitingGauge.WithLabelValues(p.currentConfig().Site).Set(float64(p.work.Len()))
+}
+
+func (p *Provisioner) discover(ctx context.Context) {
+	if p.currentConfig().Paused() {
+		p.log.Warnf("Skipping discovery while paused")
 		return
 	}
 
-	discoverCycleCtr.WithLabelValues(conf.Site).Inc()
+	discoverCycleCtr.WithLabelValues(p.currentConfig().Site).Inc()
+
+	p.expireFailures()
 
-	err := discoverProvisionableNodes(ctx)
+	err := p.discoverProvisionableNodes(ctx)
 	if err != nil {
-		errCtr.WithLabelValues(conf.Site).Inc()
-		log.Errorf("Could not discover nodes: %s", err)
+		errCtr.WithLabelValues(p.currentConfig().Site).Inc()
+		p.log.Errorf("Could not discover nodes: %s", err)
 	}
 }
 
-func discoverProvisionableNodes(ctx context.Context) error {
-	log.Infof("Looking for provisionable hosts")
+func (p *Provisioner) discoverProvisionableNodes(ctx context.Context) error {
+	cfg := p.currentConfig()
 
-	f, err := client.NewFilter(client.AgentFilter("choria_provision"))
-	if err != nil {
-		return err
+	if len(p.sources) == 0 {
+		return fmt.Errorf("no discovery source configured")
 	}
 
-	bd := broadcast.New(fw)
-	nodes, err := bd.Discover(ctx, broadcast.Collective("provisioning"), broadcast.Filter(f), broadcast.SlidingWindow(), broadcast.Timeout(2*time.Second))
-	if err != nil {
-		return err
-	}
+	p.log.Infof("Looking for provisionable hosts")
+
+	var errs []error
+
+	for i, source := range p.sources {
+		name := sourceName(source, i)
 
-	for _, n := range nodes {
-		if add(host.NewHost(n, conf)) {
-			log.Infof("Adding %s to the provision list after discovering it", n)
-			discoveredCtr.WithLabelValues(conf.Site).Inc()
+		nodes, err := source.Discover(ctx, cfg)
+		if err != nil {
+			sourceErrCtr.WithLabelValues(cfg.Site, name).Inc()
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+			continue
+		}
+
+		sourceNodesGauge.WithLabelValues(cfg.Site, name).Set(float64(len(nodes)))
+
+		// sources that can match filters only find matching nodes
+		_, matched := source.(FilterMatcher)
+
+		for _, n := range nodes {
+			h := p.newHost(n)
+			if matched {
+				h.MarkVetted()
+			}
+
+			if p.add(h) {
+				p.log.Infof("Adding %s to the provision list after discovering it using %s", n, name)
+				discoveredCtr.WithLabelValues(cfg.Site).Inc()
+			}
 		}
 	}
 
-	return nil
+	if len(errs) < len(p.sources) {
+		p.mu.Lock()
+		p.lastDiscovery = p.clock.Now()
+		p.mu.Unlock()
+	}
+
+	return errors.Join(errs...)
 }
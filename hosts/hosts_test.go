@@ -0,0 +1,1818 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choria-io/go-choria/choria"
+	ccfg "github.com/choria-io/go-choria/config"
+	"github.com/choria-io/go-choria/lifecycle"
+	"github.com/choria-io/go-choria/protocol"
+	"github.com/choria-io/go-choria/providers/agent/mcorpc"
+	"github.com/choria-io/go-choria/server/agents"
+	"github.com/choria-io/provisioner/config"
+	"github.com/choria-io/provisioner/host"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestHosts(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Hosts")
+}
+
+type fakeDiscovery struct {
+	nodes   []string
+	matches map[string]bool
+	checked []string
+}
+
+func (d *fakeDiscovery) Discover(_ context.Context, _ *config.Config) ([]string, error) {
+	return d.nodes, nil
+}
+
+func (d *fakeDiscovery) Matches(_ context.Context, _ *config.Config, identity string) (bool, error) {
+	d.checked = append(d.checked, identity)
+
+	matched, ok := d.matches[identity]
+	if !ok {
+		return false, fmt.Errorf("%s did not respond", identity)
+	}
+
+	return matched, nil
+}
+
+// listDiscovery is a source that can not match filters
+type listDiscovery struct {
+	nodes []string
+	err   error
+}
+
+func (d *listDiscovery) Name() string { return "list" }
+
+func (d *listDiscovery) Discover(_ context.Context, _ *config.Config) ([]string, error) {
+	return d.nodes, d.err
+}
+
+type fakeMembers struct {
+	members []string
+	joined  []string
+}
+
+func (m *fakeMembers) Join(member string) error {
+	m.joined = append(m.joined, member)
+	return nil
+}
+
+func (m *fakeMembers) Leave(member string) error { return nil }
+
+func (m *fakeMembers) Members(_ time.Duration) ([]string, error) {
+	return m.members, nil
+}
+
+type fakePublisher struct {
+	subjects []string
+	events   []cloudevents.Event
+	mu       sync.Mutex
+}
+
+func (f *fakePublisher) PublishRaw(target string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	event := cloudevents.NewEvent()
+	err := event.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+
+	f.subjects = append(f.subjects, target)
+	f.events = append(f.events, event)
+
+	return nil
+}
+
+type fakeLocks struct {
+	held     map[string]string
+	renewals int
+	renewErr error
+	mu       sync.Mutex
+}
+
+func (l *fakeLocks) Acquire(identity string, holder string) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.held[identity]
+	if ok {
+		return 0, fmt.Errorf("%w %s", ErrLocked, current)
+	}
+
+	l.held[identity] = holder
+
+	return 1, nil
+}
+
+func (l *fakeLocks) Renew(_ string, _ string, revision uint64) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.renewals++
+
+	if l.renewErr != nil {
+		return 0, l.renewErr
+	}
+
+	return revision + 1, nil
+}
+
+func (l *fakeLocks) Release(identity string, _ uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.held, identity)
+
+	return nil
+}
+
+func (l *fakeLocks) state() (map[string]string, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return maps.Clone(l.held), l.renewals
+}
+
+// slowQueue is a queue store whose writes block until released
+type slowQueue struct {
+	writing chan string
+	release chan struct{}
+	written []string
+	mu      sync.Mutex
+}
+
+func (q *slowQueue) Put(entry *QueueEntry) error {
+	q.writing <- entry.Identity
+	<-q.release
+
+	q.mu.Lock()
+	q.written = append(q.written, entry.Identity)
+	q.mu.Unlock()
+
+	return nil
+}
+
+func (q *slowQueue) Delete(_ string) error        { return nil }
+func (q *slowQueue) List() ([]*QueueEntry, error) { return nil, nil }
+func (q *slowQueue) entries() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return slices.Sorted(slices.Values(q.written))
+}
+
+type fakeMessage struct {
+	data []byte
+}
+
+func (m *fakeMessage) Subject() string { return "ginkgo" }
+func (m *fakeMessage) Reply() string   { return "" }
+func (m *fakeMessage) Data() []byte    { return m.data }
+func (m *fakeMessage) Msg() any        { return nil }
+
+// writeCertificates creates a CA and a certificate for name signed by it in dir
+func writeCertificates(dir string, name string) {
+	write := func(file string, kind string, der []byte) {
+		Expect(os.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600)).To(Succeed())
+	}
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ToNot(HaveOccurred())
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Ginkgo CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	Expect(err).ToNot(HaveOccurred())
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ToNot(HaveOccurred())
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	Expect(err).ToNot(HaveOccurred())
+
+	write("ca.pem", "CERTIFICATE", caDER)
+	write("cert.pem", "CERTIFICATE", certDER)
+	write("key.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+}
+
+// fakeBucket is a Key-Value bucket that hands out watchers created by the test
+type fakeBucket struct {
+	nats.KeyValue
+
+	watchers chan *fakeWatcher
+}
+
+func (b *fakeBucket) Watch(_ string, _ ...nats.WatchOpt) (nats.KeyWatcher, error) {
+	w := &fakeWatcher{updates: make(chan nats.KeyValueEntry, 10)}
+	b.watchers <- w
+
+	return w, nil
+}
+
+type fakeWatcher struct {
+	nats.KeyWatcher
+
+	updates chan nats.KeyValueEntry
+}
+
+func (w *fakeWatcher) Updates() <-chan nats.KeyValueEntry { return w.updates }
+func (w *fakeWatcher) Stop() error                        { return nil }
+
+type fakeEntry struct {
+	nats.KeyValueEntry
+
+	key   string
+	value []byte
+	op    nats.KeyValueOp
+}
+
+func (e *fakeEntry) Key() string                { return e.key }
+func (e *fakeEntry) Value() []byte              { return e.value }
+func (e *fakeEntry) Operation() nats.KeyValueOp { return e.op }
+
+type fakeQuarantine struct {
+	entries map[string]*QuarantineEntry
+	mu      sync.Mutex
+}
+
+func (q *fakeQuarantine) Put(entry *QuarantineEntry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.entries[entry.Identity] = entry
+
+	return nil
+}
+
+func (q *fakeQuarantine) Delete(identity string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.entries, identity)
+
+	return nil
+}
+
+func (q *fakeQuarantine) List() ([]*QuarantineEntry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return slices.Collect(maps.Values(q.entries)), nil
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+var _ = Describe("Hosts", func() {
+	var (
+		cfg   *config.Config
+		prov  *Provisioner
+		clock *fakeClock
+		disc  *fakeDiscovery
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		logger := logrus.New()
+		logger.Out = io.Discard
+
+		cfg = &config.Config{Site: "ginkgo", ManagementToken: "s3cret", IntervalDuration: time.Minute}
+		clock = &fakeClock{now: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)}
+		disc = &fakeDiscovery{}
+
+		prov, err = New(WithConfig(cfg), WithLogger(logrus.NewEntry(logger)), WithClock(clock), WithDiscovery(disc))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("New", func() {
+		It("Should require a configuration", func() {
+			_, err := New()
+			Expect(err).To(MatchError("configuration is required"))
+
+			_, err = New(WithConfig(nil))
+			Expect(err).To(MatchError("configuration can not be nil"))
+		})
+
+		It("Should require a framework to run", func() {
+			Expect(prov.Run(context.Background())).To(MatchError("a choria framework is required"))
+		})
+	})
+
+	Describe("Discovery", func() {
+		It("Should add discovered nodes", func() {
+			disc.nodes = []string{"one.example.net", "two.example.net"}
+			prov.discover(context.Background())
+
+			snap := prov.Snapshot()
+			Expect(snap.Hosts).To(HaveLen(2))
+			Expect(snap.WorkQueue).To(Equal(2))
+			Expect(snap.Hosts[0].Discovered).To(Equal(clock.now))
+			Expect(prov.Status().LastDiscovery).To(Equal(clock.now))
+		})
+
+		It("Should not discover while paused", func() {
+			cfg.Pause()
+			disc.nodes = []string{"one.example.net"}
+			prov.discover(context.Background())
+
+			Expect(prov.Snapshot().Hosts).To(BeEmpty())
+			Expect(prov.Status().LastDiscovery).To(BeZero())
+		})
+	})
+
+	Describe("Pause State", func() {
+		It("Should watch again when the watch ends", func() {
+			DeferCleanup(func(d time.Duration) { bucketWatchRetry = d }, bucketWatchRetry)
+			bucketWatchRetry = 10 * time.Millisecond
+
+			ctx, cancel := context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+
+			bucket := &fakeBucket{watchers: make(chan *fakeWatcher, 10)}
+			prov.wg.Add(1)
+			go prov.watchBucket(ctx, bucket, pauseStateKey, "pause state", prov.applyPauseState)
+
+			first := <-bucket.watchers
+			first.updates <- nil
+			close(first.updates)
+
+			var second *fakeWatcher
+			Eventually(bucket.watchers).Should(Receive(&second))
+			second.updates <- &fakeEntry{key: pauseStateKey, value: []byte(`{"paused":true,"by":"ginkgo","reason":"testing"}`), op: nats.KeyValuePut}
+
+			Eventually(cfg.Paused).Should(BeTrue())
+			Expect(cfg.PauseInfo().By).To(Equal("ginkgo"))
+			Consistently(bucket.watchers, 50*time.Millisecond).ShouldNot(Receive())
+
+			cancel()
+			prov.wg.Wait()
+		})
+	})
+
+	Describe("Discovery Sources", func() {
+		var list *listDiscovery
+
+		BeforeEach(func() {
+			list = &listDiscovery{}
+			prov.sources = append(prov.sources, list)
+		})
+
+		It("Should combine nodes from all sources", func() {
+			disc.nodes = []string{"one.example.net", "two.example.net"}
+			list.nodes = []string{"two.example.net", "three.example.net"}
+
+			Expect(prov.discoverProvisionableNodes(context.Background())).To(Succeed())
+
+			snap := prov.Snapshot()
+			Expect(snap.Hosts).To(HaveLen(3))
+			Expect(snap.WorkQueue).To(Equal(3))
+		})
+
+		It("Should only vet nodes from sources that can not match filters", func() {
+			disc.nodes = []string{"one.example.net"}
+			list.nodes = []string{"two.example.net"}
+
+			Expect(prov.discoverProvisionableNodes(context.Background())).To(Succeed())
+
+			Expect(prov.hosts["one.example.net"].Vetted()).To(BeTrue())
+			Expect(prov.hosts["two.example.net"].Vetted()).To(BeFalse())
+		})
+
+		It("Should use the remaining sources when one fails", func() {
+			disc.nodes = []string{"one.example.net"}
+			list.err = fmt.Errorf("simulated failure")
+
+			Expect(prov.discoverProvisionableNodes(context.Background())).To(MatchError("list: simulated failure"))
+			Expect(prov.Snapshot().Hosts).To(HaveLen(1))
+			Expect(prov.Status().LastDiscovery).To(Equal(clock.now))
+		})
+
+		It("Should not update the last discovery time when all sources fail", func() {
+			prov.sources = []DiscoverySource{list}
+			list.err = fmt.Errorf("simulated failure")
+
+			Expect(prov.discoverProvisionableNodes(context.Background())).To(HaveOccurred())
+			Expect(prov.Status().LastDiscovery).To(BeZero())
+		})
+
+		It("Should read identities from a file", func() {
+			file := filepath.Join(GinkgoT().TempDir(), "nodes.txt")
+			Expect(os.WriteFile(file, []byte("# waiting nodes\none.example.net\n\n  two.example.net  \n"), 0600)).To(Succeed())
+
+			nodes, err := (&fileDiscovery{path: file}).Discover(context.Background(), cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nodes).To(Equal([]string{"one.example.net", "two.example.net"}))
+		})
+
+		It("Should read identities from a directory", func() {
+			dir := GinkgoT().TempDir()
+			for _, f := range []string{"one.example.net", "two.example.net", ".hidden"} {
+				Expect(os.WriteFile(filepath.Join(dir, f), nil, 0600)).To(Succeed())
+			}
+			Expect(os.Mkdir(filepath.Join(dir, "sub"), 0700)).To(Succeed())
+
+			nodes, err := (&fileDiscovery{path: dir}).Discover(context.Background(), cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nodes).To(Equal([]string{"one.example.net", "two.example.net"}))
+		})
+
+		It("Should fetch identities from a HTTP endpoint", func() {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer s3cret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				fmt.Fprint(w, `{"pending":{"nodes":["one.example.net","two.example.net"]}}`)
+			}))
+			defer srv.Close()
+
+			source := &httpDiscovery{url: srv.URL, query: "pending.nodes", client: srv.Client()}
+			_, err := source.Discover(context.Background(), cfg)
+			Expect(err).To(MatchError(ContainSubstring("401 Unauthorized")))
+
+			source.headers = map[string]string{"Authorization": "Bearer s3cret"}
+			nodes, err := source.Discover(context.Background(), cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nodes).To(Equal([]string{"one.example.net", "two.example.net"}))
+		})
+
+		It("Should parse identity lists", func() {
+			nodes, err := parseIdentityList([]byte(`["one.example.net"]`), "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nodes).To(Equal([]string{"one.example.net"}))
+
+			nodes, err = parseIdentityList([]byte(`[{"name":"one.example.net"},{"name":"two.example.net"}]`), "#.name")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nodes).To(Equal([]string{"one.example.net", "two.example.net"}))
+
+			_, err = parseIdentityList([]byte(`{"nodes":`), "")
+			Expect(err).To(MatchError("invalid JSON data"))
+
+			_, err = parseIdentityList([]byte(`{"nodes":[]}`), "")
+			Expect(err).To(MatchError("expected a list of identities"))
+
+			_, err = parseIdentityList([]byte(`["one.example.net", 1]`), "")
+			Expect(err).To(MatchError("expected a list of identities but found 1"))
+		})
+	})
+
+	Describe("Lifecycle Events", func() {
+		event := func(t lifecycle.Type, identity string) lifecycle.Event {
+			e, err := lifecycle.New(t, lifecycle.Component("provision_mode_server"), lifecycle.Identity(identity))
+			Expect(err).ToNot(HaveOccurred())
+			return e
+		}
+
+		BeforeEach(func() {
+			cfg.LifecycleEvents = []string{config.LifecycleStartup, config.LifecycleShutdown, config.LifecycleAlive}
+		})
+
+		It("Should add nodes that started or are alive", func() {
+			prov.handleEvent(event(lifecycle.Startup, "one.example.net"))
+			prov.handleEvent(event(lifecycle.Alive, "two.example.net"))
+
+			Expect(prov.Snapshot().WorkQueue).To(Equal(2))
+			Expect(prov.hosts["one.example.net"].EventSourced()).To(BeTrue())
+			Expect(prov.hosts["two.example.net"].EventSourced()).To(BeTrue())
+		})
+
+		It("Should remove queued nodes that shut down", func() {
+			prov.handleEvent(event(lifecycle.Startup, "one.example.net"))
+			prov.handleEvent(event(lifecycle.Shutdown, "one.example.net"))
+
+			Expect(prov.Snapshot().Hosts).To(BeEmpty())
+			Expect(prov.Snapshot().WorkQueue).To(Equal(0))
+		})
+
+		It("Should keep nodes that shut down while being provisioned", func() {
+			prov.handleEvent(event(lifecycle.Startup, "one.example.net"))
+			prov.setBusy(prov.hosts["one.example.net"], true)
+			prov.handleEvent(event(lifecycle.Shutdown, "one.example.net"))
+
+			Expect(prov.Snapshot().Hosts).To(HaveLen(1))
+		})
+
+		It("Should ignore events that are not enabled", func() {
+			cfg.LifecycleEvents = []string{config.LifecycleStartup}
+
+			prov.handleEvent(event(lifecycle.Alive, "one.example.net"))
+			Expect(prov.Snapshot().Hosts).To(BeEmpty())
+
+			prov.handleEvent(event(lifecycle.Startup, "one.example.net"))
+			prov.handleEvent(event(lifecycle.Shutdown, "one.example.net"))
+			Expect(prov.Snapshot().Hosts).To(HaveLen(1))
+		})
+	})
+
+	Describe("Sharding", func() {
+		var members *fakeMembers
+
+		identities := func(count int) []string {
+			var nodes []string
+			for i := 0; i < count; i++ {
+				nodes = append(nodes, fmt.Sprintf("node%d.example.net", i))
+			}
+			return nodes
+		}
+
+		BeforeEach(func() {
+			members = &fakeMembers{members: []string{"p1", "p2"}}
+			prov.members = members
+			prov.member = "p1"
+			cfg.Sharding = config.Sharding{Bucket: "MEMBERS", HeartbeatDuration: time.Second}
+		})
+
+		It("Should spread nodes between members", func() {
+			ring := newShardRing([]string{"p1", "p2", "p3"})
+
+			owned := map[string]int{}
+			for _, node := range identities(3000) {
+				owned[ring.owner(node)]++
+			}
+
+			Expect(owned).To(HaveLen(3))
+			for _, count := range owned {
+				Expect(count).To(BeNumerically("~", 1000, 300))
+			}
+		})
+
+		It("Should only move the nodes of members that left", func() {
+			before := newShardRing([]string{"p1", "p2", "p3"})
+			after := newShardRing([]string{"p1", "p2"})
+
+			for _, node := range identities(1000) {
+				if before.owner(node) != "p3" {
+					Expect(after.owner(node)).To(Equal(before.owner(node)))
+				}
+			}
+		})
+
+		It("Should only add owned nodes", func() {
+			Expect(prov.heartbeat()).To(Succeed())
+			Expect(members.joined).To(Equal([]string{"p1"}))
+			Expect(prov.Status().Members).To(Equal([]string{"p1", "p2"}))
+
+			disc.nodes = identities(100)
+			prov.discover(context.Background())
+
+			snap := prov.Snapshot()
+			Expect(len(snap.Hosts)).To(BeNumerically(">", 0))
+			Expect(len(snap.Hosts)).To(BeNumerically("<", 100))
+			for _, h := range snap.Hosts {
+				_, owned := prov.owner(h.Identity)
+				Expect(owned).To(BeTrue())
+			}
+		})
+
+		It("Should include itself when missing from the members", func() {
+			members.members = []string{"p2"}
+			Expect(prov.heartbeat()).To(Succeed())
+			Expect(prov.Status().Members).To(Equal([]string{"p1", "p2"}))
+		})
+
+		It("Should rebalance when members change", func() {
+			members.members = []string{"p1"}
+			Expect(prov.heartbeat()).To(Succeed())
+
+			disc.nodes = identities(100)
+			prov.discover(context.Background())
+			Expect(prov.Snapshot().Hosts).To(HaveLen(100))
+
+			var busy string
+			for _, node := range disc.nodes {
+				if prov.ring.owner(node) == "p1" && newShardRing([]string{"p1", "p2"}).owner(node) == "p2" {
+					busy = node
+					break
+				}
+			}
+			Expect(busy).ToNot(BeEmpty())
+			prov.setBusy(prov.hosts[busy], true)
+
+			members.members = []string{"p1", "p2"}
+			Expect(prov.heartbeat()).To(Succeed())
+
+			Expect(prov.hosts).To(HaveKey(busy))
+			for identity := range prov.hosts {
+				_, owned := prov.owner(identity)
+				Expect(owned || identity == busy).To(BeTrue())
+			}
+			Expect(len(prov.hosts)).To(BeNumerically("<", 100))
+		})
+
+		It("Should not enqueue nodes owned by other members via the API", func() {
+			Expect(prov.heartbeat()).To(Succeed())
+
+			var other string
+			for _, node := range identities(100) {
+				owner, _ := prov.owner(node)
+				if owner == "p2" {
+					other = node
+					break
+				}
+			}
+
+			mux := http.NewServeMux()
+			prov.RegisterAPI(mux)
+
+			req := httptest.NewRequest("PUT", "/api/v1/hosts/"+other, nil)
+			req.Header.Set("Authorization", "Bearer s3cret")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+			Expect(rec.Body.String()).To(ContainSubstring("is provisioned by p2"))
+		})
+	})
+
+	Describe("Locks", func() {
+		var locks *fakeLocks
+
+		BeforeEach(func() {
+			locks = &fakeLocks{held: map[string]string{}}
+			prov.locks = locks
+			prov.member = "p1"
+			cfg.Locks = config.Locks{Bucket: "LOCKS", TTLDuration: time.Hour}
+		})
+
+		It("Should not lock when disabled", func() {
+			prov.locks = nil
+
+			ctx := context.Background()
+			lease, locked, err := prov.lock(ctx, prov.newHost("one.example.net"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lease).To(BeNil())
+			Expect(locked).To(Equal(ctx))
+			Expect(lease.Err()).ToNot(HaveOccurred())
+			lease.Release()
+		})
+
+		It("Should hold the lock until released", func() {
+			lease, _, err := prov.lock(context.Background(), prov.newHost("one.example.net"))
+			Expect(err).ToNot(HaveOccurred())
+
+			held, _ := locks.state()
+			Expect(held).To(Equal(map[string]string{"one.example.net": "p1"}))
+
+			_, _, err = prov.lock(context.Background(), prov.newHost("one.example.net"))
+			Expect(err).To(MatchError(ErrLocked))
+			Expect(err).To(MatchError("locked by another provisioner p1"))
+
+			lease.Release()
+			lease.Release()
+
+			held, _ = locks.state()
+			Expect(held).To(BeEmpty())
+		})
+
+		It("Should renew held locks", func() {
+			cfg.Locks.TTLDuration = 30 * time.Millisecond
+
+			lease, _, err := prov.lock(context.Background(), prov.newHost("one.example.net"))
+			Expect(err).ToNot(HaveOccurred())
+			defer lease.Release()
+
+			Eventually(func() int {
+				_, renewals := locks.state()
+				return renewals
+			}).Should(BeNumerically(">", 1))
+		})
+
+		It("Should cancel provisioning when the lock is lost", func() {
+			cfg.Locks.TTLDuration = 30 * time.Millisecond
+			locks.renewErr = fmt.Errorf("wrong last sequence")
+
+			lease, locked, err := prov.lock(context.Background(), prov.newHost("one.example.net"))
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(locked.Done()).Should(BeClosed())
+			Expect(lease.Err()).To(MatchError(ErrLockLost))
+			Expect(lease.Err()).To(MatchError("lost the lock on one.example.net: wrong last sequence"))
+
+			// renewing stops with the first failure
+			_, renewals := locks.state()
+			Expect(renewals).To(Equal(1))
+			Consistently(func() int {
+				_, renewals := locks.state()
+				return renewals
+			}, 100*time.Millisecond).Should(Equal(1))
+
+			// the stale lease is not released as another provisioner might hold the lock by now
+			lease.Release()
+			held, _ := locks.state()
+			Expect(held).To(HaveKey("one.example.net"))
+		})
+
+		It("Should not report released leases as lost", func() {
+			lease, locked, err := prov.lock(context.Background(), prov.newHost("one.example.net"))
+			Expect(err).ToNot(HaveOccurred())
+
+			lease.Release()
+			Expect(locked.Done()).To(BeClosed())
+			Expect(lease.Err()).ToNot(HaveOccurred())
+		})
+
+		It("Should skip nodes locked by another provisioner", func() {
+			locks.held["one.example.net"] = "p2"
+			Expect(prov.Enqueue("one.example.net")).To(BeTrue())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			prov.wg.Add(1)
+			go prov.provisioner(ctx, ctx, 1)
+
+			var skipped *host.Host
+			Eventually(prov.done).Should(Receive(&skipped))
+			Expect(skipped.Identity).To(Equal("one.example.net"))
+			Expect(skipped.Attempts()).To(Equal(0))
+
+			cancel()
+			prov.wg.Wait()
+		})
+	})
+
+	Describe("Drain", func() {
+		var (
+			work       context.Context
+			cancelWork context.CancelFunc
+			target     *host.Host
+		)
+
+		BeforeEach(func() {
+			work, cancelWork = context.WithCancel(context.Background())
+			DeferCleanup(cancelWork)
+
+			target = prov.newHost("one.example.net")
+			prov.setBusy(target, true)
+		})
+
+		It("Should wait for busy workers to finish", func() {
+			cfg.Timeouts.DrainDuration = time.Minute
+
+			prov.wg.Add(1)
+			go func() {
+				defer prov.wg.Done()
+				time.Sleep(20 * time.Millisecond)
+				Expect(work.Err()).ToNot(HaveOccurred())
+				prov.setBusy(target, false)
+			}()
+
+			Expect(prov.drain(cancelWork)).To(BeEmpty())
+			Expect(work.Err()).To(HaveOccurred())
+		})
+
+		It("Should cancel and report provisions that do not finish in time", func() {
+			cfg.Timeouts.DrainDuration = 20 * time.Millisecond
+
+			prov.wg.Add(1)
+			go func() {
+				defer prov.wg.Done()
+				<-work.Done()
+			}()
+
+			Expect(prov.drain(cancelWork)).To(Equal([]string{"one.example.net"}))
+			Expect(work.Err()).To(HaveOccurred())
+		})
+
+		It("Should not hand out queued hosts once stopped", func() {
+			Expect(prov.Enqueue("two.example.net")).To(BeTrue())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, ok := prov.work.Pop(ctx)
+			Expect(ok).To(BeFalse())
+			Expect(prov.work.Len()).To(Equal(1))
+		})
+	})
+
+	Describe("Audit Log", func() {
+		var file string
+
+		BeforeEach(func() {
+			file = filepath.Join(GinkgoT().TempDir(), "audit.jsonl")
+			Expect(prov.setupAuditLog(file)).To(Succeed())
+			DeferCleanup(func() { prov.audit.Close() })
+		})
+
+		readRecords := func() []AuditRecord {
+			f, err := os.Open(file)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+
+			var records []AuditRecord
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var record AuditRecord
+				Expect(json.Unmarshal(scanner.Bytes(), &record)).To(Succeed())
+				records = append(records, record)
+			}
+
+			return records
+		}
+
+		It("Should append a record for every attempt", func() {
+			one := host.NewHost("one.example.net", cfg)
+			one.MarkEventSourced()
+			one.StartAttempt()
+			prov.recordAudit(one, time.Now().Add(-time.Second), fmt.Errorf("%w by helper: testing", host.ErrDeferred))
+
+			two := host.NewHost("two.example.net", cfg)
+			two.StartAttempt()
+			two.StartAttempt()
+			prov.recordAudit(two, time.Now(), fmt.Errorf("rpc failed"))
+
+			records := readRecords()
+			Expect(records).To(HaveLen(2))
+
+			Expect(records[0].Identity).To(Equal("one.example.net"))
+			Expect(records[0].Source).To(Equal("event"))
+			Expect(records[0].Outcome).To(Equal(AuditDeferred))
+			Expect(records[0].Attempt).To(Equal(1))
+			Expect(records[0].Duration).To(BeNumerically(">=", 1))
+
+			Expect(records[1].Identity).To(Equal("two.example.net"))
+			Expect(records[1].Source).To(Equal("discovery"))
+			Expect(records[1].Outcome).To(Equal(AuditFailed))
+			Expect(records[1].Attempt).To(Equal(2))
+			Expect(records[1].Error).To(Equal("rpc failed"))
+
+			// a new provisioner appends to the existing log
+			Expect(prov.audit.Close()).To(Succeed())
+			Expect(prov.setupAuditLog(file)).To(Succeed())
+			prov.recordAudit(one, time.Now(), nil)
+			Expect(readRecords()).To(HaveLen(3))
+		})
+
+		It("Should not write once closed", func() {
+			Expect(prov.audit.Close()).To(Succeed())
+			Expect(prov.audit.Write(&AuditRecord{Identity: "one.example.net"})).To(MatchError("audit log is closed"))
+		})
+	})
+
+	Describe("Host States", func() {
+		It("Should report the state of known hosts", func() {
+			Expect(prov.Enqueue("one.example.net")).To(BeTrue())
+			Expect(prov.Enqueue("two.example.net")).To(BeTrue())
+
+			snap := prov.Snapshot()
+			Expect(snap.Hosts).To(HaveLen(2))
+			Expect(snap.Hosts[0].State).To(Equal(host.StateQueued))
+			Expect(snap.Hosts[0].StateSince).To(Equal(clock.now))
+			Expect(snap.Hosts[0].Transitions).To(Equal([]host.Transition{{State: host.StateQueued, Time: clock.now}}))
+
+			counts := prov.countStates()
+			Expect(counts).To(HaveLen(len(host.States)))
+			Expect(counts[host.StateQueued]).To(Equal(2))
+			Expect(counts[host.StateHelper]).To(Equal(0))
+
+			prov.updateStates()
+			Expect(testutil.ToFloat64(hostsInStateGauge.WithLabelValues("ginkgo", host.StateQueued))).To(Equal(2.0))
+
+			Expect(prov.Remove("one.example.net")).To(BeTrue())
+			prov.updateStates()
+			Expect(testutil.ToFloat64(hostsInStateGauge.WithLabelValues("ginkgo", host.StateQueued))).To(Equal(1.0))
+		})
+
+		It("Should report hosts that are being provisioned", func() {
+			choriaCfg := ccfg.NewConfigForTests()
+			choriaCfg.Choria.MiddlewareHosts = []string{"nats://127.0.0.1:1"}
+			fw, err := choria.NewWithConfig(choriaCfg)
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg.Retries = config.Retries{Inventory: 100}
+			cfg.Timeouts.StaleDuration = time.Hour
+
+			Expect(prov.Enqueue("slow.example.net")).To(BeTrue())
+			target, ok := prov.work.Pop(context.Background())
+			Expect(ok).To(BeTrue())
+			target.StartAttempt()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			provisioned := make(chan struct{})
+			go func() {
+				defer close(provisioned)
+				target.Provision(ctx, fw)
+			}()
+			DeferCleanup(func() {
+				cancel()
+				Eventually(provisioned, 10*time.Second).Should(BeClosed())
+			})
+
+			// the inventory request can not succeed so the host stays in this state until canceled
+			Eventually(func() string { state, _ := target.State(); return state }).Should(Equal(host.StateInventory))
+
+			snapped := make(chan *Snapshot, 1)
+			go func() { snapped <- prov.Snapshot() }()
+
+			var snap *Snapshot
+			Eventually(snapped).Should(Receive(&snap))
+			Expect(snap.Hosts).To(HaveLen(1))
+			Expect(snap.Hosts[0].State).To(Equal(host.StateInventory))
+			Expect(snap.Hosts[0].Attempts).To(Equal(1))
+			Expect(snap.Hosts[0].Transitions).To(HaveLen(2))
+
+			Expect(prov.Enqueue("other.example.net")).To(BeTrue())
+		})
+
+		It("Should request a gauge update when hosts change state", func() {
+			Expect(prov.Enqueue("one.example.net")).To(BeTrue())
+			Expect(prov.statesChanged).To(Receive())
+
+			target, ok := prov.work.Pop(context.Background())
+			Expect(ok).To(BeTrue())
+
+			clock.now = clock.now.Add(time.Minute)
+			prov.retryNow(target)
+			Expect(prov.statesChanged).To(Receive())
+
+			transitions := target.Transitions()
+			Expect(transitions).To(HaveLen(2))
+			Expect(transitions[1].Time).To(Equal(clock.now))
+		})
+	})
+
+	Describe("Staleness", func() {
+		It("Should measure how long hosts waited using the provisioner clock", func() {
+			choriaCfg := ccfg.NewConfigForTests()
+			choriaCfg.Choria.MiddlewareHosts = []string{"nats://127.0.0.1:1"}
+			fw, err := choria.NewWithConfig(choriaCfg)
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg.Timeouts.StaleDuration = time.Hour
+			clock.now = time.Now().Add(-24 * time.Hour)
+
+			target := prov.newHost("stale.example.net")
+			clock.now = clock.now.Add(2 * time.Hour)
+
+			_, err = target.Provision(context.Background(), fw)
+			Expect(err).To(MatchError("skipping node that's been waiting 2h0m0s"))
+		})
+	})
+
+	Describe("Filters", func() {
+		event := func(identity string) *host.Host {
+			h := prov.newHost(identity)
+			h.MarkEventSourced()
+			return h
+		}
+
+		BeforeEach(func() {
+			disc.matches = map[string]bool{"dc1.example.net": true, "dc2.example.net": false}
+			cfg.DiscoveryFilter = config.DiscoveryFilter{Facts: []string{"dc=dc1"}}
+		})
+
+		It("Should not check nodes when no filter is set", func() {
+			cfg.DiscoveryFilter = config.DiscoveryFilter{}
+
+			h := event("dc2.example.net")
+			Expect(prov.vet(context.Background(), h)).To(BeTrue())
+			Expect(h.Vetted()).To(BeTrue())
+			Expect(disc.checked).To(BeEmpty())
+		})
+
+		It("Should skip nodes that do not match", func() {
+			h := event("dc1.example.net")
+			Expect(prov.vet(context.Background(), h)).To(BeTrue())
+			Expect(h.Vetted()).To(BeTrue())
+
+			h = event("dc2.example.net")
+			Expect(prov.vet(context.Background(), h)).To(BeFalse())
+			Expect(h.Vetted()).To(BeFalse())
+
+			h = event("dc3.example.net")
+			Expect(prov.vet(context.Background(), h)).To(BeFalse())
+
+			Expect(disc.checked).To(Equal([]string{"dc1.example.net", "dc2.example.net", "dc3.example.net"}))
+		})
+	})
+
+	Describe("Work Queue", func() {
+		var queue *workQueue
+
+		hostAt := func(identity string, age time.Duration) *host.Host {
+			return host.NewHostAt(identity, cfg, clock.now.Add(-age))
+		}
+
+		popAll := func() []string {
+			var order []string
+			for queue.Len() > 0 {
+				h, ok := queue.Pop(context.Background())
+				Expect(ok).To(BeTrue())
+				order = append(order, h.Identity)
+			}
+
+			return order
+		}
+
+		BeforeEach(func() {
+			cfg.QueueOrder = []string{config.QueueOrderPriority, config.QueueOrderEvent, config.QueueOrderOldest}
+			queue = newWorkQueue(3, cfg)
+		})
+
+		It("Should order by the configured keys", func() {
+			event := hostAt("event.example.net", time.Second)
+			event.MarkEventSourced()
+			infra := hostAt("infra.example.net", time.Second)
+			infra.SetPriority(10)
+
+			Expect(queue.Push(hostAt("new.example.net", time.Second))).To(BeTrue())
+			Expect(queue.Push(hostAt("old.example.net", time.Minute))).To(BeTrue())
+			Expect(queue.Push(event)).To(BeTrue())
+			Expect(popAll()).To(Equal([]string{"event.example.net", "old.example.net", "new.example.net"}))
+
+			Expect(queue.Push(hostAt("old.example.net", time.Minute))).To(BeTrue())
+			Expect(queue.Push(event)).To(BeTrue())
+			Expect(queue.Push(infra)).To(BeTrue())
+			Expect(popAll()).To(Equal([]string{"infra.example.net", "event.example.net", "old.example.net"}))
+		})
+
+		It("Should keep the order hosts were added in when keys are equal", func() {
+			cfg.QueueOrder = nil
+			queue.Reorder(cfg)
+
+			Expect(queue.Push(hostAt("one.example.net", time.Second))).To(BeTrue())
+			Expect(queue.Push(hostAt("two.example.net", time.Minute))).To(BeTrue())
+			Expect(queue.Push(hostAt("three.example.net", time.Hour))).To(BeTrue())
+			Expect(popAll()).To(Equal([]string{"one.example.net", "two.example.net", "three.example.net"}))
+		})
+
+		It("Should resolve unknown priorities first when priorities are fetched", func() {
+			cfg.Priority.Fact = "provisioning.priority"
+			queue.Reorder(cfg)
+
+			known := hostAt("known.example.net", time.Minute)
+			known.SetPriority(10)
+
+			Expect(queue.Push(known)).To(BeTrue())
+			Expect(queue.Push(hostAt("unknown.example.net", time.Second))).To(BeTrue())
+			Expect(popAll()).To(Equal([]string{"unknown.example.net", "known.example.net"}))
+		})
+
+		It("Should be bounded and support removal", func() {
+			Expect(queue.Push(hostAt("one.example.net", time.Second))).To(BeTrue())
+			Expect(queue.Push(hostAt("two.example.net", time.Second))).To(BeTrue())
+			Expect(queue.Push(hostAt("three.example.net", time.Second))).To(BeTrue())
+			Expect(queue.Push(hostAt("four.example.net", time.Second))).To(BeFalse())
+
+			Expect(queue.Remove("two.example.net")).To(BeTrue())
+			Expect(queue.Remove("two.example.net")).To(BeFalse())
+			Expect(popAll()).To(Equal([]string{"one.example.net", "three.example.net"}))
+		})
+
+		It("Should stop waiting when the context is done", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			_, ok := queue.Pop(ctx)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("Should remove removed hosts from the queue", func() {
+			Expect(prov.Enqueue("one.example.net")).To(BeTrue())
+			Expect(prov.Enqueue("two.example.net")).To(BeTrue())
+			Expect(prov.work.Len()).To(Equal(2))
+
+			Expect(prov.Remove("one.example.net")).To(BeTrue())
+			Expect(prov.work.Len()).To(Equal(1))
+		})
+	})
+
+	Describe("Retries", func() {
+		var target *host.Host
+
+		BeforeEach(func() {
+			cfg.Backoff = config.Backoff{MaxAttempts: 3, InitialDuration: time.Hour, MaxDuration: 4 * time.Hour}
+
+			Expect(prov.Enqueue("retry.example.net")).To(BeTrue())
+
+			var ok bool
+			target, ok = prov.work.Pop(context.Background())
+			Expect(ok).To(BeTrue())
+
+			target.StartAttempt()
+			target.FailAttempt(fmt.Errorf("rpc failed"))
+		})
+
+		It("Should schedule failed hosts to be retried", func() {
+			prov.retry(target, fmt.Errorf("rpc failed"))
+
+			snap := prov.Snapshot()
+			Expect(snap.Hosts).To(HaveLen(1))
+			Expect(snap.Hosts[0].Attempts).To(Equal(1))
+			Expect(snap.Hosts[0].LastError).To(Equal("rpc failed"))
+			Expect(snap.Hosts[0].RetryAt).To(Equal(clock.now.Add(time.Hour)))
+
+			clock.now = clock.now.Add(2 * time.Minute)
+			Expect(prov.add(prov.newHost("retry.example.net"))).To(BeFalse())
+			Expect(prov.work.Len()).To(Equal(0))
+
+			prov.retryNow(target)
+			Expect(prov.work.Len()).To(Equal(1))
+			Expect(prov.Snapshot().Hosts[0].RetryAt.IsZero()).To(BeTrue())
+		})
+
+		It("Should not retry removed hosts", func() {
+			prov.retry(target, fmt.Errorf("rpc failed"))
+			Expect(prov.Remove("retry.example.net")).To(BeTrue())
+
+			prov.retryNow(target)
+			Expect(prov.work.Len()).To(Equal(0))
+		})
+
+		It("Should give up after the maximum attempts", func() {
+			target.RestoreAttempts(3, "rpc failed")
+			prov.retry(target, fmt.Errorf("rpc failed"))
+			Expect(prov.done).To(Receive(Equal(target)))
+		})
+
+		It("Should not retry deferred hosts", func() {
+			prov.retry(target, fmt.Errorf("%w by helper: later", host.ErrDeferred))
+			Expect(prov.done).To(Receive(Equal(target)))
+		})
+	})
+
+	Describe("Quarantine", func() {
+		BeforeEach(func() {
+			cfg.QuarantineThreshold = 2
+		})
+
+		It("Should quarantine nodes that reach the threshold", func() {
+			target := prov.newHost("bad.example.net")
+			Expect(prov.recordFailure(target, fmt.Errorf("invalid csr"))).To(BeFalse())
+			Expect(prov.IsQuarantined("bad.example.net")).To(BeFalse())
+
+			clock.now = clock.now.Add(time.Minute)
+			Expect(prov.recordFailure(target, fmt.Errorf("invalid jwt"))).To(BeTrue())
+			Expect(prov.IsQuarantined("bad.example.net")).To(BeTrue())
+
+			entries := prov.Quarantined()
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Identity).To(Equal("bad.example.net"))
+			Expect(entries[0].Since).To(Equal(clock.now))
+			Expect(entries[0].Failures).To(Equal(2))
+			Expect(entries[0].Errors).To(HaveLen(2))
+			Expect(entries[0].Errors[1].Error).To(Equal("invalid jwt"))
+
+			Expect(prov.Enqueue("bad.example.net")).To(BeFalse())
+			Expect(prov.Status().Quarantined).To(Equal(1))
+
+			Expect(prov.Release("bad.example.net")).To(BeTrue())
+			Expect(prov.Release("bad.example.net")).To(BeFalse())
+			Expect(prov.Enqueue("bad.example.net")).To(BeTrue())
+		})
+
+		It("Should ignore deferrals, successes and disabled quarantine", func() {
+			target := prov.newHost("node.example.net")
+			Expect(prov.recordFailure(target, fmt.Errorf("%w by helper: later", host.ErrDeferred))).To(BeFalse())
+			Expect(prov.recordFailure(target, fmt.Errorf("failed"))).To(BeFalse())
+
+			prov.clearFailures(target)
+			Expect(prov.recordFailure(target, fmt.Errorf("failed"))).To(BeFalse())
+
+			cfg.QuarantineThreshold = 0
+			Expect(prov.recordFailure(target, fmt.Errorf("failed"))).To(BeFalse())
+			Expect(prov.Quarantined()).To(BeEmpty())
+		})
+
+		It("Should forget failures after the expiry", func() {
+			cfg.QuarantineExpiryDuration = time.Hour
+			target := prov.newHost("node.example.net")
+			Expect(prov.recordFailure(target, fmt.Errorf("failed"))).To(BeFalse())
+
+			clock.now = clock.now.Add(2 * time.Hour)
+			Expect(prov.recordFailure(target, fmt.Errorf("failed"))).To(BeFalse())
+			Expect(prov.failures["node.example.net"].count).To(Equal(1))
+
+			prov.recordFailure(prov.newHost("gone.example.net"), fmt.Errorf("failed"))
+			clock.now = clock.now.Add(30 * time.Minute)
+			prov.expireFailures()
+			Expect(prov.failures).To(HaveLen(2))
+
+			clock.now = clock.now.Add(time.Hour)
+			prov.expireFailures()
+			Expect(prov.failures).To(BeEmpty())
+		})
+
+		It("Should persist the quarantine", func() {
+			store := &fakeQuarantine{entries: make(map[string]*QuarantineEntry)}
+			prov.quarantine = store
+
+			target := prov.newHost("bad.example.net")
+			prov.recordFailure(target, fmt.Errorf("invalid csr"))
+			Expect(prov.recordFailure(target, fmt.Errorf("invalid jwt"))).To(BeTrue())
+			Expect(store.entries).To(HaveKey("bad.example.net"))
+			Expect(store.entries["bad.example.net"].Failures).To(Equal(2))
+
+			restarted, err := New(WithConfig(cfg), WithQuarantineStore(store))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(restarted.restoreQuarantine()).To(Succeed())
+			Expect(restarted.IsQuarantined("bad.example.net")).To(BeTrue())
+			Expect(restarted.Enqueue("bad.example.net")).To(BeFalse())
+
+			Expect(restarted.Release("bad.example.net")).To(BeTrue())
+			Expect(store.entries).To(BeEmpty())
+		})
+
+		It("Should follow changes made by other instances", func() {
+			j, err := json.Marshal(&QuarantineEntry{Identity: "bad.example.net", Since: clock.now, Failures: 2})
+			Expect(err).ToNot(HaveOccurred())
+
+			prov.applyQuarantine(&fakeEntry{key: queueKey("bad.example.net"), value: j, op: nats.KeyValuePut})
+			Expect(prov.IsQuarantined("bad.example.net")).To(BeTrue())
+			Expect(prov.Quarantined()[0].Failures).To(Equal(2))
+
+			prov.applyQuarantine(&fakeEntry{key: queueKey("bad.example.net"), op: nats.KeyValueDelete})
+			Expect(prov.IsQuarantined("bad.example.net")).To(BeFalse())
+		})
+	})
+
+	Describe("Dry Run", func() {
+		It("Should plan nodes once until reloaded", func() {
+			prov.markPlanned(prov.newHost("planned.example.net"))
+			Expect(prov.Status().Planned).To(Equal(1))
+
+			disc.nodes = []string{"planned.example.net", "other.example.net"}
+			prov.discover(context.Background())
+			Expect(prov.Snapshot().Hosts).To(HaveLen(1))
+			Expect(prov.Snapshot().Hosts[0].Identity).To(Equal("other.example.net"))
+
+			prov.Reconfigure(cfg)
+			Expect(prov.Status().Planned).To(Equal(0))
+			Expect(prov.add(prov.newHost("planned.example.net"))).To(BeTrue())
+		})
+
+		It("Should plan nodes again when enqueued", func() {
+			prov.markPlanned(prov.newHost("planned.example.net"))
+			Expect(prov.add(prov.newHost("planned.example.net"))).To(BeFalse())
+
+			Expect(prov.Enqueue("planned.example.net")).To(BeTrue())
+			Expect(prov.Status().Planned).To(Equal(0))
+		})
+	})
+
+	Describe("Durable Queue", func() {
+		var store *DirectoryQueueStore
+
+		BeforeEach(func() {
+			var err error
+
+			store, err = NewDirectoryQueueStore(filepath.Join(GinkgoT().TempDir(), "queue"))
+			Expect(err).ToNot(HaveOccurred())
+
+			cfg.Timeouts.StaleDuration = time.Hour
+			prov.queue = store
+		})
+
+		It("Should persist queued hosts", func() {
+			Expect(prov.Enqueue("one.example.net")).To(BeTrue())
+			Expect(prov.Enqueue("two.example.net")).To(BeTrue())
+
+			// writes happen outside of the provisioner lock
+			entries, err := store.List()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+
+			prov.flushQueue()
+			entries, err = store.List()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+
+			Expect(prov.Remove("one.example.net")).To(BeTrue())
+			prov.flushQueue()
+
+			entries, err = store.List()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Identity).To(Equal("two.example.net"))
+			Expect(entries[0].Discovered).To(BeTemporally("==", clock.now))
+		})
+
+		It("Should not hold up the provisioner while writing", func() {
+			slow := &slowQueue{writing: make(chan string, 10), release: make(chan struct{})}
+			prov.queue = slow
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go prov.writeQueue(ctx)
+
+			Expect(prov.Enqueue("one.example.net")).To(BeTrue())
+			Eventually(slow.writing).Should(Receive(Equal("one.example.net")))
+
+			// the write of one.example.net is blocked, the provisioner lock should be free
+			Expect(prov.Enqueue("two.example.net")).To(BeTrue())
+			Expect(prov.Snapshot().Hosts).To(HaveLen(2))
+
+			close(slow.release)
+			Eventually(slow.entries).Should(Equal([]string{"one.example.net", "two.example.net"}))
+		})
+
+		It("Should restore hosts and drop stale ones", func() {
+			Expect(store.Put(&QueueEntry{Identity: "fresh.example.net", Discovered: clock.now.Add(-time.Minute), Attempts: 2, LastError: "rpc failed"})).To(Succeed())
+			Expect(store.Put(&QueueEntry{Identity: "stale.example.net", Discovered: clock.now.Add(-2 * time.Hour)})).To(Succeed())
+
+			Expect(prov.restoreQueue()).To(Succeed())
+
+			snap := prov.Snapshot()
+			Expect(snap.Hosts).To(HaveLen(1))
+			Expect(snap.Hosts[0].Identity).To(Equal("fresh.example.net"))
+
+			h, ok := prov.work.Pop(context.Background())
+			Expect(ok).To(BeTrue())
+			attempts, lastError := h.Attempts()
+			Expect(attempts).To(Equal(2))
+			Expect(lastError).To(Equal("rpc failed"))
+
+			entries, err := store.List()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+	})
+
+	Describe("Events", func() {
+		var pub *fakePublisher
+
+		BeforeEach(func() {
+			pub = &fakePublisher{}
+			prov.publisher = pub
+			prov.member = "prov1.example.net"
+			cfg.EventsSubject = "choria.provisioner.event"
+		})
+
+		It("Should only publish when a subject is configured", func() {
+			cfg.EventsSubject = ""
+			Expect(prov.add(prov.newHost("one.example.net"))).To(BeTrue())
+			Expect(pub.events).To(BeEmpty())
+		})
+
+		It("Should publish node events as CloudEvents", func() {
+			Expect(prov.add(prov.newHost("one.example.net"))).To(BeTrue())
+			prov.notify(host.NewHost("one.example.net", cfg), fmt.Errorf("%w by helper: testing", host.ErrDeferred))
+			prov.notify(host.NewHost("two.example.net", cfg), fmt.Errorf("rpc failed"))
+
+			Expect(pub.subjects).To(Equal([]string{
+				"choria.provisioner.event.discovered",
+				"choria.provisioner.event.deferred",
+				"choria.provisioner.event.failed",
+			}))
+
+			event := pub.events[1]
+			Expect(event.Type()).To(Equal("io.choria.provisioner.v1.deferred"))
+			Expect(event.Source()).To(Equal("io.choria.provisioner"))
+			Expect(event.Subject()).To(Equal("one.example.net"))
+			Expect(event.Time()).To(Equal(clock.Now()))
+
+			var data NodeEvent
+			Expect(event.DataAs(&data)).To(Succeed())
+			Expect(data).To(Equal(NodeEvent{
+				Identity:    "one.example.net",
+				Event:       EventDeferred,
+				Site:        "ginkgo",
+				Provisioner: "prov1.example.net",
+				Reason:      "provisioning deferred by helper: testing",
+			}))
+		})
+	})
+
+	Describe("Webhooks", func() {
+		type received struct {
+			path      string
+			signature string
+			payload   WebhookPayload
+		}
+
+		var (
+			srv      *httptest.Server
+			mu       sync.Mutex
+			requests []received
+			failures int
+		)
+
+		BeforeEach(func() {
+			requests = nil
+			failures = 0
+
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if failures > 0 {
+					failures--
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				body, err := io.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+
+				rec := received{path: r.URL.Path, signature: r.Header.Get("X-Choria-Signature")}
+				Expect(json.Unmarshal(body, &rec.payload)).To(Succeed())
+				Expect(rec.signature).To(Or(BeEmpty(), Equal("sha256="+webhookSignature("s3cret", body))))
+
+				requests = append(requests, rec)
+			}))
+			DeferCleanup(srv.Close)
+		})
+
+		deliveries := func() []received {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return append([]received{}, requests...)
+		}
+
+		start := func(endpoints ...config.WebhookEndpoint) {
+			cfg.Webhooks = config.Webhooks{Endpoints: endpoints, QueueSize: 10, Attempts: 3, TimeoutDuration: time.Second}
+			Expect(prov.setupWebhooks(cfg)).To(Succeed())
+			prov.webhooks.delay = func(int) time.Duration { return time.Millisecond }
+
+			ctx, cancel := context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+			prov.webhooks.start(ctx)
+		}
+
+		It("Should post signed payloads to the endpoints that want them", func() {
+			start(
+				config.WebhookEndpoint{Name: "all", URL: srv.URL + "/all", Secret: "s3cret"},
+				config.WebhookEndpoint{Name: "provisioned", URL: srv.URL + "/provisioned", Outcomes: []string{config.WebhookProvisioned}},
+				config.WebhookEndpoint{Name: "two", URL: srv.URL + "/two", Identities: []string{`^two\.`}},
+			)
+
+			prov.postWebhook(host.NewHost("one.example.net", cfg), config.WebhookFailed, fmt.Errorf("rpc failed"))
+			Eventually(deliveries).Should(HaveLen(1))
+
+			prov.postWebhook(host.NewHost("two.example.net", cfg), config.WebhookProvisioned, nil)
+			Eventually(deliveries).Should(HaveLen(4))
+
+			requests := deliveries()
+			Expect(requests[0].path).To(Equal("/all"))
+			Expect(requests[0].signature).ToNot(BeEmpty())
+			Expect(requests[0].payload.Identity).To(Equal("one.example.net"))
+			Expect(requests[0].payload.Outcome).To(Equal(config.WebhookFailed))
+			Expect(requests[0].payload.Error).To(Equal("rpc failed"))
+			Expect(requests[0].payload.Site).To(Equal("ginkgo"))
+
+			paths := []string{requests[1].path, requests[2].path, requests[3].path}
+			Expect(paths).To(ConsistOf("/all", "/provisioned", "/two"))
+		})
+
+		It("Should retry failed deliveries", func() {
+			start(config.WebhookEndpoint{Name: "all", URL: srv.URL})
+			failures = 2
+
+			prov.postWebhook(host.NewHost("one.example.net", cfg), config.WebhookQuarantined, fmt.Errorf("rpc failed"))
+			Eventually(deliveries).Should(HaveLen(1))
+			Expect(deliveries()[0].payload.Outcome).To(Equal(config.WebhookQuarantined))
+		})
+
+		It("Should drop deliveries when the queue is full", func() {
+			cfg.Webhooks = config.Webhooks{Endpoints: []config.WebhookEndpoint{{Name: "all", URL: srv.URL}}, QueueSize: 1, Attempts: 1, TimeoutDuration: time.Second}
+			Expect(prov.setupWebhooks(cfg)).To(Succeed())
+
+			prov.postWebhook(host.NewHost("one.example.net", cfg), config.WebhookProvisioned, nil)
+			prov.postWebhook(host.NewHost("two.example.net", cfg), config.WebhookProvisioned, nil)
+			Expect(prov.webhooks.endpoints[0].queue).To(HaveLen(1))
+		})
+
+		It("Should not hold up endpoints behind a slow one", func() {
+			stuck := make(chan struct{})
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-stuck }))
+			DeferCleanup(slow.Close)
+			DeferCleanup(func() { close(stuck) })
+
+			start(
+				config.WebhookEndpoint{Name: "slow", URL: slow.URL},
+				config.WebhookEndpoint{Name: "healthy", URL: srv.URL + "/healthy"},
+			)
+
+			for i := range 5 {
+				prov.postWebhook(host.NewHost(fmt.Sprintf("n%d.example.net", i), cfg), config.WebhookProvisioned, nil)
+			}
+
+			Eventually(deliveries).Should(HaveLen(5))
+			for _, d := range deliveries() {
+				Expect(d.path).To(Equal("/healthy"))
+			}
+		})
+
+		It("Should deliver queued and retrying webhooks when stopping", func() {
+			start(config.WebhookEndpoint{Name: "all", URL: srv.URL})
+			prov.webhooks.delay = func(int) time.Duration { return time.Hour }
+
+			mu.Lock()
+			failures = 1
+			mu.Unlock()
+
+			prov.postWebhook(host.NewHost("one.example.net", cfg), config.WebhookFailed, fmt.Errorf("rpc failed"))
+			Eventually(func() int {
+				prov.webhooks.mu.Lock()
+				defer prov.webhooks.mu.Unlock()
+				return len(prov.webhooks.retries)
+			}).Should(Equal(1))
+
+			prov.postWebhook(host.NewHost("two.example.net", cfg), config.WebhookProvisioned, nil)
+			prov.webhooks.stop(time.Second)
+
+			Expect(deliveries()).To(HaveLen(2))
+		})
+
+		It("Should drop what is left after the grace period", func() {
+			stuck := make(chan struct{})
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-stuck }))
+			DeferCleanup(slow.Close)
+			DeferCleanup(func() { close(stuck) })
+
+			start(config.WebhookEndpoint{Name: "stuck", URL: slow.URL})
+			dropped := testutil.ToFloat64(webhookDroppedCtr.WithLabelValues("ginkgo", "stuck"))
+
+			for i := range 3 {
+				prov.postWebhook(host.NewHost(fmt.Sprintf("n%d.example.net", i), cfg), config.WebhookProvisioned, nil)
+			}
+
+			prov.webhooks.stop(50 * time.Millisecond)
+			Expect(testutil.ToFloat64(webhookDroppedCtr.WithLabelValues("ginkgo", "stuck"))).To(Equal(dropped + 3))
+
+			prov.postWebhook(host.NewHost("late.example.net", cfg), config.WebhookFailed, nil)
+			Expect(testutil.ToFloat64(webhookDroppedCtr.WithLabelValues("ginkgo", "stuck"))).To(Equal(dropped + 4))
+			Expect(prov.webhooks.endpoints[0].queue).To(BeEmpty())
+		})
+	})
+
+	Describe("Hooks", func() {
+		It("Should notify based on the outcome", func() {
+			var failed, deferred []string
+
+			prov, err := New(WithConfig(cfg),
+				OnFailed(func(identity string, _ error) { failed = append(failed, identity) }),
+				OnDeferred(func(identity string, _ error) { deferred = append(deferred, identity) }),
+			)
+			Expect(err).ToNot(HaveOccurred())
+
+			prov.notify(host.NewHost("one.example.net", cfg), fmt.Errorf("%w by helper: testing", host.ErrDeferred))
+			prov.notify(host.NewHost("two.example.net", cfg), fmt.Errorf("rpc failed"))
+
+			Expect(deferred).To(Equal([]string{"one.example.net"}))
+			Expect(failed).To(Equal([]string{"two.example.net"}))
+		})
+	})
+
+	Describe("API", func() {
+		var mux *http.ServeMux
+
+		BeforeEach(func() {
+			mux = http.NewServeMux()
+			prov.RegisterAPI(mux)
+		})
+
+		request := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			return rec
+		}
+
+		It("Should require a token", func() {
+			Expect(request("GET", "/api/v1/hosts", "", "").Code).To(Equal(http.StatusUnauthorized))
+			Expect(request("GET", "/api/v1/hosts", "wrong", "").Code).To(Equal(http.StatusUnauthorized))
+
+			cfg.ManagementToken = ""
+			Expect(request("GET", "/api/v1/hosts", "s3cret", "").Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("Should pause, resume and flip", func() {
+			rec := request("POST", "/api/v1/pause", "s3cret", `{"by":"ginkgo","reason":"testing"}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(cfg.Paused()).To(BeTrue())
+			Expect(cfg.PauseInfo().By).To(Equal("ginkgo"))
+			Expect(cfg.PauseInfo().Reason).To(Equal("testing"))
+
+			res := apiPauseResponse{}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &res)).To(Succeed())
+			Expect(res.Paused).To(BeTrue())
+
+			Expect(request("POST", "/api/v1/resume", "s3cret", "").Code).To(Equal(http.StatusOK))
+			Expect(cfg.Paused()).To(BeFalse())
+
+			Expect(request("POST", "/api/v1/flip", "s3cret", "").Code).To(Equal(http.StatusOK))
+			Expect(cfg.Paused()).To(BeTrue())
+		})
+
+		It("Should manage the quarantine", func() {
+			cfg.QuarantineThreshold = 1
+			Expect(prov.recordFailure(prov.newHost("bad.example.net"), fmt.Errorf("invalid csr"))).To(BeTrue())
+
+			rec := request("GET", "/api/v1/quarantine", "s3cret", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			var entries []QuarantineEntry
+			Expect(json.Unmarshal(rec.Body.Bytes(), &entries)).To(Succeed())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Errors[0].Error).To(Equal("invalid csr"))
+
+			Expect(request("PUT", "/api/v1/hosts/bad.example.net", "s3cret", "").Code).To(Equal(http.StatusConflict))
+			Expect(request("DELETE", "/api/v1/quarantine/bad.example.net", "s3cret", "").Code).To(Equal(http.StatusOK))
+			Expect(request("DELETE", "/api/v1/quarantine/bad.example.net", "s3cret", "").Code).To(Equal(http.StatusNotFound))
+			Expect(request("PUT", "/api/v1/hosts/bad.example.net", "s3cret", "").Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should manage hosts", func() {
+			Expect(request("PUT", "/api/v1/hosts/one.example.net", "s3cret", "").Code).To(Equal(http.StatusOK))
+			Expect(request("PUT", "/api/v1/hosts/two.example.net", "s3cret", "").Code).To(Equal(http.StatusOK))
+
+			rec := request("GET", "/api/v1/hosts", "s3cret", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			snap := Snapshot{}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &snap)).To(Succeed())
+			Expect(snap.WorkQueue).To(Equal(2))
+			Expect(snap.Hosts).To(HaveLen(2))
+			Expect(snap.Hosts[0].Identity).To(Equal("one.example.net"))
+
+			Expect(request("DELETE", "/api/v1/hosts/one.example.net", "s3cret", "").Code).To(Equal(http.StatusOK))
+			Expect(request("DELETE", "/api/v1/hosts/one.example.net", "s3cret", "").Code).To(Equal(http.StatusNotFound))
+			Expect(prov.Snapshot().Hosts).To(HaveLen(1))
+		})
+
+		It("Should report status", func() {
+			Expect(request("PUT", "/api/v1/hosts/one.example.net", "s3cret", "").Code).To(Equal(http.StatusOK))
+
+			rec := request("GET", "/api/v1/status", "s3cret", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			status := Status{}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &status)).To(Succeed())
+			Expect(status.Site).To(Equal("ginkgo"))
+			Expect(status.Leader).To(BeTrue())
+			Expect(status.KnownHosts).To(Equal(1))
+			Expect(status.WorkQueue).To(Equal(1))
+		})
+
+		It("Should trigger discovery", func() {
+			Expect(request("POST", "/api/v1/discover", "s3cret", "").Code).To(Equal(http.StatusAccepted))
+			Expect(prov.discoverTrigger).To(Receive())
+		})
+	})
+
+	Describe("Agent", func() {
+		It("Should have a DDL for every action", func() {
+			ddl := map[string]any{}
+			Expect(json.Unmarshal(AgentDDL(), &ddl)).To(Succeed())
+			Expect(ddl["metadata"].(map[string]any)["name"]).To(Equal(agentName))
+
+			var actions []string
+			for _, action := range ddl["actions"].([]any) {
+				actions = append(actions, action.(map[string]any)["action"].(string))
+			}
+
+			Expect(actions).To(Equal([]string{"pause", "resume", "status", "hosts"}))
+		})
+
+		It("Should pause and resume", func() {
+			reply := &mcorpc.Reply{}
+			prov.agentPauseAction(context.Background(), &mcorpc.Request{CallerID: "choria=ginkgo.mcollective", Data: json.RawMessage(`{"reason":"testing"}`)}, reply, nil, nil)
+			Expect(reply.Statuscode).To(Equal(mcorpc.OK))
+			Expect(cfg.Paused()).To(BeTrue())
+			Expect(cfg.PauseInfo().By).To(Equal("rpc:choria=ginkgo.mcollective"))
+			Expect(cfg.PauseInfo().Reason).To(Equal("testing"))
+
+			reply = &mcorpc.Reply{}
+			prov.agentResumeAction(context.Background(), &mcorpc.Request{Data: json.RawMessage(`{"by":"ginkgo"}`)}, reply, nil, nil)
+			Expect(reply.Statuscode).To(Equal(mcorpc.OK))
+			Expect(cfg.Paused()).To(BeFalse())
+			Expect(reply.Data.(apiPauseResponse).PauseInfo.By).To(Equal("ginkgo"))
+		})
+
+		It("Should authorize requests using the Action Policy", func() {
+			dir := GinkgoT().TempDir()
+			writeCertificates(dir, "ginkgo.mcollective")
+
+			Expect(os.MkdirAll(filepath.Join(dir, "policies"), 0700)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "policies", agentName+".policy"), []byte("policy default deny\nallow\tchoria=ginkgo.mcollective\tpause status\t*\t*\n"), 0600)).To(Succeed())
+
+			choriaCfg := ccfg.NewConfigForTests()
+			choriaCfg.ConfigFile = filepath.Join(dir, "choria.conf")
+			choriaCfg.OverrideCertname = "ginkgo.mcollective"
+			choriaCfg.DisableSecurityProviderVerify = false
+			choriaCfg.Choria.SecurityProvider = "file"
+			choriaCfg.Choria.FileSecurityCA = filepath.Join(dir, "ca.pem")
+			choriaCfg.Choria.FileSecurityCertificate = filepath.Join(dir, "cert.pem")
+			choriaCfg.Choria.FileSecurityKey = filepath.Join(dir, "key.pem")
+			choriaCfg.RPCAuthorization = true
+			choriaCfg.RPCAuthorizationProvider = "action_policy"
+
+			fw, err := choria.NewWithConfig(choriaCfg)
+			Expect(err).ToNot(HaveOccurred())
+			prov.fw = fw
+
+			info := &agentInfo{identity: fw.Certname(), configFile: choriaCfg.ConfigFile, started: time.Now(), log: prov.log}
+			agent := prov.newAgent(info)
+			Expect(agent.ServerInfo()).To(Equal(info))
+
+			request := func(action string) *mcorpc.Reply {
+				id, err := fw.NewRequestID()
+				Expect(err).ToNot(HaveOccurred())
+				req, err := fw.NewRequest(protocol.RequestV1, agentName, "ginkgo.mcollective", fw.CallerID(), 60, id, "ginkgo")
+				Expect(err).ToNot(HaveOccurred())
+				req.SetMessage([]byte(fmt.Sprintf(`{"agent":%q,"action":%q,"data":{}}`, agentName, action)))
+
+				sreq, err := fw.NewSecureRequest(context.Background(), req)
+				Expect(err).ToNot(HaveOccurred())
+				transport, err := fw.NewTransportForSecureRequest(sreq)
+				Expect(err).ToNot(HaveOccurred())
+				transport.SetReplyTo("ginkgo.reply")
+				data, err := transport.JSON()
+				Expect(err).ToNot(HaveOccurred())
+
+				replies := make(chan *agents.AgentReply, 1)
+				prov.handleAgentRequest(context.Background(), agent, info, nil, &fakeMessage{data: data}, replies)
+				Expect(replies).To(HaveLen(1))
+
+				reply := &mcorpc.Reply{}
+				Expect(json.Unmarshal((<-replies).Body, reply)).To(Succeed())
+
+				return reply
+			}
+
+			Expect(request("pause").Statuscode).To(Equal(mcorpc.OK))
+			Expect(cfg.Paused()).To(BeTrue())
+
+			reply := request("resume")
+			Expect(reply.Statuscode).To(Equal(mcorpc.Aborted))
+			Expect(reply.Statusmsg).To(Equal("You are not authorized to call this agent or action"))
+			Expect(cfg.Paused()).To(BeTrue())
+		})
+
+		It("Should report status and hosts", func() {
+			Expect(prov.Enqueue("one.example.net")).To(BeTrue())
+
+			reply := &mcorpc.Reply{}
+			prov.agentStatusAction(context.Background(), &mcorpc.Request{}, reply, nil, nil)
+			Expect(reply.Data.(*Status).KnownHosts).To(Equal(1))
+
+			reply = &mcorpc.Reply{}
+			prov.agentHostsAction(context.Background(), &mcorpc.Request{}, reply, nil, nil)
+			Expect(reply.Data.(*Snapshot).Hosts[0].Identity).To(Equal("one.example.net"))
+		})
+	})
+})
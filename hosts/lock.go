@@ -0,0 +1,202 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/providers/kv"
+	"github.com/choria-io/provisioner/config"
+	"github.com/choria-io/provisioner/host"
+	"github.com/nats-io/nats.go"
+)
+
+// ErrLocked indicates another provisioner holds the lock on a node
+var ErrLocked = errors.New("locked by another provisioner")
+
+// ErrLockLost indicates the lock on a node could not be renewed so another provisioner might take it
+var ErrLockLost = errors.New("lost the lock")
+
+// LockStore grants leases on nodes so only one provisioner provisions a node at a time, leases expire unless renewed
+type LockStore interface {
+	// Acquire locks identity for holder returning the lock revision, ErrLocked is returned when it is already locked
+	Acquire(identity string, holder string) (uint64, error)
+	// Renew extends the lease held at revision returning the new revision
+	Renew(identity string, holder string, revision uint64) (uint64, error)
+	// Release unlocks identity if the lease is still held at revision
+	Release(identity string, revision uint64) error
+}
+
+type lockEntry struct {
+	Holder string    `json:"holder"`
+	Since  time.Time `json:"since"`
+}
+
+// kvLockStore keeps locks in a Key-Value bucket where the bucket TTL expires leases that are not renewed
+type kvLockStore struct {
+	kv nats.KeyValue
+}
+
+func (s *kvLockStore) Acquire(identity string, holder string) (uint64, error) {
+	j, err := json.Marshal(lockEntry{Holder: holder, Since: time.Now().UTC()})
+	if err != nil {
+		return 0, err
+	}
+
+	rev, err := s.kv.Create(queueKey(identity), j)
+	if errors.Is(err, nats.ErrKeyExists) {
+		entry, gerr := s.kv.Get(queueKey(identity))
+		if gerr == nil {
+			var current lockEntry
+			if json.Unmarshal(entry.Value(), &current) == nil && current.Holder != "" {
+				return 0, fmt.Errorf("%w %s", ErrLocked, current.Holder)
+			}
+		}
+
+		return 0, ErrLocked
+	}
+
+	return rev, err
+}
+
+func (s *kvLockStore) Renew(identity string, holder string, revision uint64) (uint64, error) {
+	j, err := json.Marshal(lockEntry{Holder: holder, Since: time.Now().UTC()})
+	if err != nil {
+		return 0, err
+	}
+
+	return s.kv.Update(queueKey(identity), j, revision)
+}
+
+func (s *kvLockStore) Release(identity string, revision uint64) error {
+	return s.kv.Delete(queueKey(identity), nats.LastRevision(revision))
+}
+
+// lease is a held lock on a node, it is renewed in the background until released and ctx
+// is canceled when a renewal fails as the node might then be provisioned by another instance
+type lease struct {
+	store    LockStore
+	identity string
+	holder   string
+	revision uint64
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	log      func(format string, args ...any)
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (l *lease) keep(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rev, err := l.store.Renew(l.identity, l.holder, l.revision)
+			if err != nil {
+				// the revision is stale once a renewal failed so the lock can not be renewed or released anymore
+				l.log("Could not renew the lock on %s, canceling its provisioning: %s", l.identity, err)
+				l.cancel(fmt.Errorf("%w on %s: %s", ErrLockLost, l.identity, err))
+				return
+			}
+			l.revision = rev
+
+		case <-l.stop:
+			err := l.store.Release(l.identity, l.revision)
+			if err != nil {
+				l.log("Could not release the lock on %s: %s", l.identity, err)
+			}
+			return
+		}
+	}
+}
+
+// Release stops renewing the lease and unlocks the node, safe to call on a nil lease
+func (l *lease) Release() {
+	if l == nil {
+		return
+	}
+
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		l.cancel(nil)
+	})
+}
+
+// Err is the reason the lease was lost, nil while it is held or after it was released
+func (l *lease) Err() error {
+	if l == nil {
+		return nil
+	}
+
+	cause := context.Cause(l.ctx)
+	if errors.Is(cause, ErrLockLost) {
+		return cause
+	}
+
+	return nil
+}
+
+// setupLocks configures the node locks unless a store was supplied as an option
+func (p *Provisioner) setupLocks(ctx context.Context, conn inter.Connector, cfg *config.Config) error {
+	if p.member == "" {
+		p.member = p.fw.Certname()
+	}
+
+	if p.locks != nil || !cfg.Locks.Enabled() {
+		return nil
+	}
+
+	p.log.Infof("Locking nodes while provisioning them using the %s Key-Value bucket", cfg.Locks.Bucket)
+	bucket, err := p.fw.KV(ctx, conn, cfg.Locks.Bucket, true, kv.WithHistory(1), kv.WithTTL(cfg.Locks.TTLDuration))
+	if err != nil {
+		return fmt.Errorf("could not access locks bucket %s: %s", cfg.Locks.Bucket, err)
+	}
+
+	p.locks = &kvLockStore{kv: bucket}
+
+	return nil
+}
+
+// lock acquires a lease on target that is renewed until released, the returned context is derived from ctx and is
+// canceled should the lease be lost, the lease is nil and ctx is returned when locking is not enabled
+func (p *Provisioner) lock(ctx context.Context, target *host.Host) (*lease, context.Context, error) {
+	if p.locks == nil {
+		return nil, ctx, nil
+	}
+
+	cfg := p.currentConfig()
+
+	rev, err := p.locks.Acquire(target.Identity, p.member)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := &lease{
+		store:    p.locks,
+		identity: target.Identity,
+		holder:   p.member,
+		revision: rev,
+		log:      p.log.Errorf,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancelCause(ctx)
+
+	go l.keep(cfg.Locks.TTLDuration / 3)
+
+	return l, l.ctx, nil
+}
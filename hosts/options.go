@@ -0,0 +1,145 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"fmt"
+
+	"github.com/choria-io/go-choria/choria"
+	"github.com/choria-io/provisioner/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Option configures a Provisioner
+type Option func(*Provisioner) error
+
+type hooks struct {
+	provisioned []func(identity string)
+	failed      []func(identity string, err error)
+	deferred    []func(identity string, err error)
+	shutdown    []func(identity string)
+}
+
+// WithFramework sets the Choria framework used to connect to the network, required to Run
+func WithFramework(fw *choria.Framework) Option {
+	return func(p *Provisioner) error {
+		if fw == nil {
+			return fmt.Errorf("framework can not be nil")
+		}
+
+		p.fw = fw
+
+		return nil
+	}
+}
+
+// WithConfig sets the provisioner configuration, required
+func WithConfig(cfg *config.Config) Option {
+	return func(p *Provisioner) error {
+		if cfg == nil {
+			return fmt.Errorf("configuration can not be nil")
+		}
+
+		p.conf = cfg
+
+		return nil
+	}
+}
+
+// WithLogger sets the logger, defaults to one from the framework
+func WithLogger(log *logrus.Entry) Option {
+	return func(p *Provisioner) error {
+		p.log = log
+		return nil
+	}
+}
+
+// WithDiscovery adds sources of provisionable nodes, defaults to the sources set in the configuration
+func WithDiscovery(sources ...DiscoverySource) Option {
+	return func(p *Provisioner) error {
+		p.sources = append(p.sources, sources...)
+		return nil
+	}
+}
+
+// WithQueueStore sets the durable queue backend, defaults to one set in the configuration
+func WithQueueStore(store QueueStore) Option {
+	return func(p *Provisioner) error {
+		p.queue = store
+		return nil
+	}
+}
+
+// WithQuarantineStore sets the store persisting quarantined nodes, defaults to one set in the configuration
+func WithQuarantineStore(store QuarantineStore) Option {
+	return func(p *Provisioner) error {
+		p.quarantine = store
+		return nil
+	}
+}
+
+// WithMembershipStore sets the store tracking the members that shard nodes, defaults to one set in the configuration
+func WithMembershipStore(store MembershipStore) Option {
+	return func(p *Provisioner) error {
+		p.members = store
+		return nil
+	}
+}
+
+// WithLockStore sets the store holding node locks, defaults to one set in the configuration
+func WithLockStore(store LockStore) Option {
+	return func(p *Provisioner) error {
+		p.locks = store
+		return nil
+	}
+}
+
+// WithEventPublisher sets where node events are published, defaults to the middleware connection
+func WithEventPublisher(publisher EventPublisher) Option {
+	return func(p *Provisioner) error {
+		p.publisher = publisher
+		return nil
+	}
+}
+
+// WithClock sets the clock used to timestamp hosts, defaults to the system clock
+func WithClock(clock Clock) Option {
+	return func(p *Provisioner) error {
+		p.clock = clock
+		return nil
+	}
+}
+
+// OnProvisioned calls cb after a node was configured and restarted
+func OnProvisioned(cb func(identity string)) Option {
+	return func(p *Provisioner) error {
+		p.hooks.provisioned = append(p.hooks.provisioned, cb)
+		return nil
+	}
+}
+
+// OnFailed calls cb after provisioning a node failed
+func OnFailed(cb func(identity string, err error)) Option {
+	return func(p *Provisioner) error {
+		p.hooks.failed = append(p.hooks.failed, cb)
+		return nil
+	}
+}
+
+// OnDeferred calls cb when the helper or admission policy deferred provisioning a node
+func OnDeferred(cb func(identity string, err error)) Option {
+	return func(p *Provisioner) error {
+		p.hooks.deferred = append(p.hooks.deferred, cb)
+		return nil
+	}
+}
+
+// OnShutdown calls cb after a node was shut down on request of the helper or admission policy
+func OnShutdown(cb func(identity string)) Option {
+	return func(p *Provisioner) error {
+		p.hooks.shutdown = append(p.hooks.shutdown, cb)
+		return nil
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/providers/kv"
+	"github.com/choria-io/provisioner/config"
+	"github.com/nats-io/nats.go"
+)
+
+const pauseStateKey = "pause_state"
+
+// kvPauseStore persists the pause state in a Choria Key-Value bucket shared by all instances
+type kvPauseStore struct {
+	kv nats.KeyValue
+}
+
+func (s *kvPauseStore) Load() (*config.PauseInfo, error) {
+	entry, err := s.kv.Get(pauseStateKey)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return &config.PauseInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load pause state: %s", err)
+	}
+
+	return parsePauseInfo(entry.Value())
+}
+
+func (s *kvPauseStore) Save(info *config.PauseInfo) error {
+	j, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Put(pauseStateKey, j)
+	if err != nil {
+		return fmt.Errorf("could not save pause state: %s", err)
+	}
+
+	return nil
+}
+
+func parsePauseInfo(j []byte) (*config.PauseInfo, error) {
+	info := &config.PauseInfo{}
+	err := json.Unmarshal(j, info)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse pause state: %s", err)
+	}
+
+	return info, nil
+}
+
+// setupPauseStore configures persistence of the pause state and restores any previously saved state
+func (p *Provisioner) setupPauseStore(ctx context.Context, conn inter.Connector, cfg *config.Config) error {
+	switch {
+	case cfg.PauseStateFile != "":
+		p.log.Infof("Persisting pause state in %s", cfg.PauseStateFile)
+		cfg.SetPauseStore(config.NewFilePauseStore(cfg.PauseStateFile))
+
+	case cfg.PauseStateBucket != "":
+		p.log.Infof("Persisting pause state in the %s Key-Value bucket", cfg.PauseStateBucket)
+		bucket, err := p.fw.KV(ctx, conn, cfg.PauseStateBucket, true, kv.WithHistory(1))
+		if err != nil {
+			return fmt.Errorf("could not access pause state bucket %s: %s", cfg.PauseStateBucket, err)
+		}
+
+		cfg.SetPauseStore(&kvPauseStore{kv: bucket})
+
+		p.wg.Add(1)
+		go p.watchBucket(ctx, bucket, pauseStateKey, "pause state", p.applyPauseState)
+
+	default:
+		return nil
+	}
+
+	err := cfg.RestorePauseState()
+	if err != nil {
+		return err
+	}
+
+	info := cfg.PauseInfo()
+	if info.Paused {
+		p.log.Warnf("Provisioning was paused by %q at %v: %s", info.By, info.Since, info.Reason)
+	}
+
+	return nil
+}
+
+// applyPauseState applies pause state changes made by other instances sharing the bucket
+func (p *Provisioner) applyPauseState(entry nats.KeyValueEntry) {
+	if entry.Operation() != nats.KeyValuePut {
+		return
+	}
+
+	info, err := parsePauseInfo(entry.Value())
+	if err != nil {
+		p.log.Errorf("Could not process pause state update: %s", err)
+		return
+	}
+
+	cfg := p.currentConfig()
+	if info.Paused != cfg.PauseInfo().Paused {
+		p.log.Warnf("Pause state changed to %t by %q: %s", info.Paused, info.By, info.Reason)
+	}
+
+	cfg.ApplyPauseInfo(*info)
+}
+
+// watchMaintenance pauses provisioning while a maintenance window is active
+func (p *Provisioner) watchMaintenance(ctx context.Context) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		cfg := p.currentConfig()
+		if cfg.UpdateMaintenanceState(time.Now()) {
+			window, ends := cfg.MaintenanceState()
+			if window != "" {
+				p.log.Warnf("Pausing provisioning during maintenance window %s until %v", window, ends)
+			} else {
+				p.log.Warnf("Resuming provisioning after maintenance window ended")
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -6,78 +6,273 @@ package hosts
 
 import (
 	"context"
-	"sync"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/choria-io/provisioner/config"
 	"github.com/choria-io/provisioner/host"
 )
 
-func provisioner(ctx context.Context, wg *sync.WaitGroup, i int) {
-	defer wg.Done()
+// provisioner takes hosts from the work queue until ctx is done, hosts being provisioned continue until work is done
+func (p *Provisioner) provisioner(ctx context.Context, work context.Context, i int) {
+	defer p.wg.Done()
 
-	log.Debugf("Provisioner worker %d starting", i)
+	p.log.Debugf("Provisioner worker %d starting", i)
 
 	for {
-		select {
-		case host := <-work:
-			log.Infof("Provisioning %s", host.Identity)
-
-			// the work queue might have an old entry that is not in the host lists anymore
-			// so we short circuit here to avoid provisioning machines that might be stale.
-			//
-			// hosts are removed when elections pause processing etc
-			//
-			// we dont pass to done, its already not on the hosts list.
-			if !isCurrent(host) {
-				continue
+		host, ok := p.work.Pop(ctx)
+		if !ok {
+			p.log.Infof("Worker %d exiting on context", i)
+			return
+		}
+
+		// the work queue might have an old entry that is not in the host lists anymore
+		// so we short circuit here to avoid provisioning machines that might be stale.
+		//
+		// hosts are removed when elections pause processing etc
+		//
+		// we dont pass to done, its already not on the hosts list.
+		if !p.isCurrent(host) {
+			continue
+		}
+
+		// hosts that were queued before a reload should be provisioned using the new configuration
+		host.UseConfig(p.currentConfig())
+
+		// nodes found by their startup events or some discovery sources might not match the discovery filter
+		if !host.Vetted() && !p.vet(ctx, host) {
+			p.done <- host
+			continue
+		}
+
+		// the priority is only known once the node was asked for it, it then waits its turn
+		_, known := host.Priority()
+		if !known && p.currentConfig().UsesPriority() {
+			p.resolvePriority(ctx, host)
+			continue
+		}
+
+		// another provisioner might be busy with the same node, it is found again should that one fail
+		lease, locked, err := p.lock(work, host)
+		if err != nil {
+			if errors.Is(err, ErrLocked) {
+				lockedCtr.WithLabelValues(p.currentConfig().Site).Inc()
+				p.log.Infof("Skipping %s: %s", host.Identity, err)
+			} else {
+				lockErrCtr.WithLabelValues(p.currentConfig().Site).Inc()
+				p.log.Errorf("Could not lock %s: %s", host.Identity, err)
 			}
 
-			delay, err := provisionTarget(ctx, host)
-			if err != nil {
-				provErrCtr.WithLabelValues(conf.Site).Inc()
-				log.Errorf("Could not provision %s: %s", host.Identity, err)
-				done <- host
+			p.done <- host
+			continue
+		}
+
+		p.log.Infof("Provisioning %s", host.Identity)
+
+		host.StartAttempt()
+		p.persist(host)
+
+		started := time.Now()
+		delay, err := p.provisionTarget(locked, host)
+		if err != nil && lease.Err() != nil {
+			lockErrCtr.WithLabelValues(p.currentConfig().Site).Inc()
+			err = fmt.Errorf("%s: %s", lease.Err(), err)
+		}
+		p.recordAudit(host, started, err)
+		p.notify(host, err)
+		if err != nil {
+			lease.Release()
+			host.FailAttempt(err)
+			provErrCtr.WithLabelValues(p.currentConfig().Site).Inc()
+			p.log.Errorf("Could not provision %s: %s", host.Identity, err)
+
+			if p.recordFailure(host, err) {
+				p.postWebhook(host, config.WebhookQuarantined, err)
+				p.done <- host
 				continue
 			}
 
-			log.Infof("Provisioned %s", host.Identity)
-			if delay {
-				time.AfterFunc(60*time.Second, func() { done <- host })
-			} else {
-				done <- host
-			}
+			p.retry(host, err)
+			continue
+		}
 
-		case <-ctx.Done():
-			log.Infof("Worker %d exiting on context", i)
-			return
+		if host.Planned() {
+			p.log.Infof("Planned provisioning %s without changing it", host.Identity)
+			p.markPlanned(host)
+		} else {
+			p.log.Infof("Provisioned %s", host.Identity)
+		}
+		p.clearFailures(host)
+
+		// a restarted provisioner should not resume hosts that are cooling down after being provisioned
+		p.forget(host)
+
+		// the lock is kept while the node restarts so others do not provision it again meanwhile
+		if delay {
+			time.AfterFunc(p.currentConfig().Timeouts.CooldownDuration, func() {
+				lease.Release()
+				p.done <- host
+			})
+		} else {
+			lease.Release()
+			p.done <- host
 		}
 	}
 }
 
-func provisionTarget(ctx context.Context, target *host.Host) (bool, error) {
-	busyWorkerGauge.WithLabelValues(conf.Site).Inc()
-	defer busyWorkerGauge.WithLabelValues(conf.Site).Dec()
+// retry schedules another attempt at provisioning a failed host after a delay that grows with every attempt,
+// deferred hosts and those that used all their attempts are removed and will be provisioned when found again
+func (p *Provisioner) retry(target *host.Host, err error) {
+	cfg := p.currentConfig()
+	attempts, _ := target.Attempts()
+
+	switch {
+	case errors.Is(err, host.ErrDeferred):
+		p.done <- target
+		return
+
+	case attempts >= cfg.Backoff.MaxAttempts:
+		p.log.Errorf("Giving up on %s after %d attempts: %s", target.Identity, attempts, err)
+		retriesExhaustedCtr.WithLabelValues(cfg.Site).Inc()
+		p.done <- target
+		return
+	}
+
+	delay := cfg.Backoff.Delay(attempts)
+
+	p.mu.Lock()
+	p.retrying[target.Identity] = p.clock.Now().Add(delay)
+	p.mu.Unlock()
+
+	// records the error in the durable queue
+	p.persist(target)
+
+	retryCtr.WithLabelValues(cfg.Site).Inc()
+	p.log.Warnf("Retrying %s in %v after %d failed attempts", target.Identity, delay, attempts)
+
+	time.AfterFunc(delay, func() { p.retryNow(target) })
+}
 
-	delay, err := target.Provision(ctx, fw)
+// retryNow queues a host that was waiting to be retried unless it was removed from the hosts list meanwhile
+func (p *Provisioner) retryNow(target *host.Host) {
+	p.mu.Lock()
+	current := p.hosts[target.Identity] == target
+	if current {
+		delete(p.retrying, target.Identity)
+	}
+	p.mu.Unlock()
+
+	if !current {
+		return
+	}
+
+	target.SetQueued(p.clock.Now())
+	p.requeue(target)
+}
+
+// vet checks that a node matches the discovery filter, nodes that could not be checked are skipped until found again
+func (p *Provisioner) vet(ctx context.Context, target *host.Host) bool {
+	cfg := p.currentConfig()
+
+	if p.matcher == nil || cfg.DiscoveryFilter.Empty() {
+		target.MarkVetted()
+		return true
+	}
+
+	matched, err := p.matcher.Matches(ctx, cfg, target.Identity)
+	switch {
+	case err != nil:
+		p.log.Warnf("Skipping %s, could not determine if it matches the discovery filter: %s", target.Identity, err)
+		return false
+
+	case !matched:
+		p.log.Infof("Skipping %s that does not match the discovery filter", target.Identity)
+		return false
+	}
+
+	target.MarkVetted()
+
+	return true
+}
+
+// resolvePriority asks the node for its priority and queues it again, nodes that fail to report one get priority 0
+func (p *Provisioner) resolvePriority(ctx context.Context, target *host.Host) {
+	priority, err := target.ResolvePriority(ctx, p.fw)
+	if err != nil {
+		p.log.Warnf("Could not determine the priority of %s, using %d: %s", target.Identity, priority, err)
+	} else {
+		p.log.Infof("Queueing %s with priority %d", target.Identity, priority)
+	}
+
+	p.requeue(target)
+}
+
+func (p *Provisioner) provisionTarget(ctx context.Context, target *host.Host) (bool, error) {
+	busyWorkerGauge.WithLabelValues(p.currentConfig().Site).Inc()
+	defer busyWorkerGauge.WithLabelValues(p.currentConfig().Site).Dec()
+
+	p.setBusy(target, true)
+	defer p.setBusy(target, false)
+
+	target.OnEvent(func(identity string, event string) { p.publishEvent(identity, event, "") })
+
+	delay, err := target.Provision(ctx, p.fw)
 	if err != nil {
 		return false, err
 	}
 
-	provisionedCtr.WithLabelValues(conf.Site).Inc()
+	if target.Planned() {
+		dryRunCtr.WithLabelValues(p.currentConfig().Site).Inc()
+		return delay, nil
+	}
+
+	provisionedCtr.WithLabelValues(p.currentConfig().Site).Inc()
 
 	return delay, nil
 }
 
-func finisher(ctx context.Context, wg *sync.WaitGroup) {
-	defer wg.Done()
+// notify publishes events, posts webhooks and calls the lifecycle hooks matching the outcome of a provisioning attempt
+func (p *Provisioner) notify(target *host.Host, err error) {
+	switch {
+	case errors.Is(err, host.ErrDeferred):
+		p.publishEvent(target.Identity, EventDeferred, err.Error())
+		for _, cb := range p.hooks.deferred {
+			cb(target.Identity, err)
+		}
+
+	case err != nil:
+		p.publishEvent(target.Identity, EventFailed, err.Error())
+		p.postWebhook(target, config.WebhookFailed, err)
+		for _, cb := range p.hooks.failed {
+			cb(target.Identity, err)
+		}
+
+	case target.ShutdownRequested():
+		p.publishEvent(target.Identity, EventShutdown, "")
+		p.postWebhook(target, config.WebhookShutdown, nil)
+		for _, cb := range p.hooks.shutdown {
+			cb(target.Identity)
+		}
+
+	case target.Provisioned():
+		p.postWebhook(target, config.WebhookProvisioned, nil)
+		for _, cb := range p.hooks.provisioned {
+			cb(target.Identity)
+		}
+	}
+}
+
+func (p *Provisioner) finisher(ctx context.Context) {
+	defer p.wg.Done()
 
 	for {
 		select {
-		case host := <-done:
-			log.Debugf("Removing %s from the provision list", host.Identity)
-			remove(host)
+		case host := <-p.done:
+			p.log.Debugf("Removing %s from the provision list", host.Identity)
+			p.remove(host)
 		case <-ctx.Done():
-			log.Info("Finisher exiting on context")
+			p.log.Info("Finisher exiting on context")
 			return
 		}
 	}
@@ -0,0 +1,78 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/choria-io/provisioner/host"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// Events published about nodes as they are provisioned
+const (
+	EventDiscovered = "discovered"
+	EventDeferred   = "deferred"
+	EventShutdown   = "shutdown"
+	EventUpgrading  = host.EventUpgrading
+	EventConfigured = host.EventConfigured
+	EventRestarted  = host.EventRestarted
+	EventFailed     = "failed"
+)
+
+// EventPublisher publishes messages to the middleware
+type EventPublisher interface {
+	PublishRaw(target string, data []byte) error
+}
+
+// NodeEvent is the data of the CloudEvent published when a node changes state
+type NodeEvent struct {
+	Identity    string `json:"identity"`
+	Event       string `json:"event"`
+	Site        string `json:"site,omitempty"`
+	Provisioner string `json:"provisioner,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// publishEvent publishes event about identity as a CloudEvent to a subject below events_subject, nothing is published without a subject
+func (p *Provisioner) publishEvent(identity string, event string, reason string) {
+	cfg := p.currentConfig()
+	if cfg.EventsSubject == "" || p.publisher == nil {
+		return
+	}
+
+	j, err := p.encodeEvent(NodeEvent{
+		Identity:    identity,
+		Event:       event,
+		Site:        cfg.Site,
+		Provisioner: p.member,
+		Reason:      reason,
+	})
+	if err == nil {
+		err = p.publisher.PublishRaw(fmt.Sprintf("%s.%s", cfg.EventsSubject, event), j)
+	}
+	if err != nil {
+		eventErrCtr.WithLabelValues(cfg.Site).Inc()
+		p.log.Errorf("Could not publish %s event for %s: %s", event, identity, err)
+	}
+}
+
+func (p *Provisioner) encodeEvent(data NodeEvent) ([]byte, error) {
+	event := cloudevents.NewEvent("1.0")
+	event.SetType(fmt.Sprintf("io.choria.provisioner.v1.%s", data.Event))
+	event.SetSource("io.choria.provisioner")
+	event.SetSubject(data.Identity)
+	event.SetID(uuid.NewString())
+	event.SetTime(p.clock.Now().UTC().Truncate(time.Millisecond))
+
+	err := event.SetData(cloudevents.ApplicationJSON, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return event.MarshalJSON()
+}
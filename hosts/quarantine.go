@@ -0,0 +1,332 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/providers/kv"
+	"github.com/choria-io/provisioner/config"
+	"github.com/choria-io/provisioner/host"
+	"github.com/nats-io/nats.go"
+)
+
+// failureHistoryLength is how many of the most recent errors are kept for each node
+const failureHistoryLength = 10
+
+// Failure is a failed provisioning attempt
+type Failure struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// QuarantineEntry is a node that will not be provisioned until released because it failed too often
+type QuarantineEntry struct {
+	Identity string    `json:"identity"`
+	Since    time.Time `json:"since"`
+	Failures int       `json:"failures"`
+	Errors   []Failure `json:"errors"`
+}
+
+// QuarantineStore persists quarantined nodes so they stay quarantined across restarts and instances
+type QuarantineStore interface {
+	Put(entry *QuarantineEntry) error
+	Delete(identity string) error
+	List() ([]*QuarantineEntry, error)
+}
+
+func parseQuarantineEntry(j []byte) (*QuarantineEntry, error) {
+	entry := &QuarantineEntry{}
+	err := json.Unmarshal(j, entry)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse quarantine entry: %s", err)
+	}
+
+	return entry, nil
+}
+
+// kvQuarantineStore persists the quarantine in a Choria Key-Value bucket shared by all instances
+type kvQuarantineStore struct {
+	kv nats.KeyValue
+}
+
+func (s *kvQuarantineStore) Put(entry *QuarantineEntry) error {
+	j, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Put(queueKey(entry.Identity), j)
+	if err != nil {
+		return fmt.Errorf("could not save quarantine entry: %s", err)
+	}
+
+	return nil
+}
+
+func (s *kvQuarantineStore) Delete(identity string) error {
+	err := s.kv.Delete(queueKey(identity))
+	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return fmt.Errorf("could not delete quarantine entry: %s", err)
+	}
+
+	return nil
+}
+
+func (s *kvQuarantineStore) List() ([]*QuarantineEntry, error) {
+	keys, err := s.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list quarantine entries: %s", err)
+	}
+
+	var entries []*QuarantineEntry
+	for _, key := range keys {
+		kve, err := s.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not load quarantine entry: %s", err)
+		}
+
+		entry, err := parseQuarantineEntry(kve.Value())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// setupQuarantineStore configures persistence of the quarantine unless a store was supplied as an option and
+// restores the nodes quarantined previously, changes made by other instances sharing the bucket are followed
+func (p *Provisioner) setupQuarantineStore(ctx context.Context, conn inter.Connector, cfg *config.Config) error {
+	if p.quarantine == nil && cfg.QuarantineBucket != "" {
+		p.log.Infof("Persisting the quarantine in the %s Key-Value bucket", cfg.QuarantineBucket)
+		bucket, err := p.fw.KV(ctx, conn, cfg.QuarantineBucket, true, kv.WithHistory(1))
+		if err != nil {
+			return fmt.Errorf("could not access quarantine bucket %s: %s", cfg.QuarantineBucket, err)
+		}
+
+		p.quarantine = &kvQuarantineStore{kv: bucket}
+
+		p.wg.Add(1)
+		go p.watchBucket(ctx, bucket, ">", "the quarantine", p.applyQuarantine)
+	}
+
+	return p.restoreQuarantine()
+}
+
+// restoreQuarantine quarantines the nodes recorded in the quarantine store
+func (p *Provisioner) restoreQuarantine() error {
+	if p.quarantine == nil {
+		return nil
+	}
+
+	entries, err := p.quarantine.List()
+	if err != nil {
+		return fmt.Errorf("could not restore the quarantine: %s", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, entry := range entries {
+		p.quarantined[entry.Identity] = entry
+	}
+	quarantinedGauge.WithLabelValues(p.currentConfig().Site).Set(float64(len(p.quarantined)))
+
+	if len(entries) > 0 {
+		p.log.Warnf("Restored %d quarantined nodes", len(entries))
+	}
+
+	return nil
+}
+
+// applyQuarantine applies changes to the quarantine made by other instances sharing the bucket
+func (p *Provisioner) applyQuarantine(entry nats.KeyValueEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch entry.Operation() {
+	case nats.KeyValuePut:
+		q, err := parseQuarantineEntry(entry.Value())
+		if err != nil {
+			p.log.Errorf("Could not process quarantine update: %s", err)
+			return
+		}
+
+		p.quarantined[q.Identity] = q
+
+	case nats.KeyValueDelete, nats.KeyValuePurge:
+		identity, err := base64.RawURLEncoding.DecodeString(entry.Key())
+		if err != nil {
+			p.log.Errorf("Could not process quarantine update for key %s: %s", entry.Key(), err)
+			return
+		}
+
+		delete(p.quarantined, string(identity))
+	}
+
+	quarantinedGauge.WithLabelValues(p.currentConfig().Site).Set(float64(len(p.quarantined)))
+}
+
+type failureHistory struct {
+	count  int
+	errors []Failure
+	last   time.Time
+}
+
+// recordFailure adds err to the failure history of target and quarantines it once the threshold is reached, returns true when quarantined
+func (p *Provisioner) recordFailure(target *host.Host, err error) bool {
+	cfg := p.currentConfig()
+	if cfg.QuarantineThreshold <= 0 || errors.Is(err, host.ErrDeferred) {
+		return false
+	}
+
+	entry := p.updateFailures(cfg, target, err)
+	if entry == nil {
+		return false
+	}
+
+	if p.quarantine != nil {
+		err = p.quarantine.Put(entry)
+		if err != nil {
+			p.log.Errorf("Could not persist the quarantine of %s: %s", target.Identity, err)
+		}
+	}
+
+	return true
+}
+
+// updateFailures updates the failure history, returns the quarantine entry when target was quarantined
+func (p *Provisioner) updateFailures(cfg *config.Config, target *host.Host, err error) *QuarantineEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+
+	// failures from long ago do not count towards quarantining a node
+	history, ok := p.failures[target.Identity]
+	if !ok || expired(history, now, cfg.QuarantineExpiryDuration) {
+		history = &failureHistory{}
+		p.failures[target.Identity] = history
+	}
+
+	history.count++
+	history.last = now
+	history.errors = append(history.errors, Failure{Time: now, Error: err.Error()})
+	if len(history.errors) > failureHistoryLength {
+		history.errors = history.errors[len(history.errors)-failureHistoryLength:]
+	}
+
+	if history.count < cfg.QuarantineThreshold {
+		return nil
+	}
+
+	p.log.Errorf("Quarantining %s after %d failed attempts: %s", target.Identity, history.count, err)
+
+	entry := &QuarantineEntry{
+		Identity: target.Identity,
+		Since:    now,
+		Failures: history.count,
+		Errors:   history.errors,
+	}
+	p.quarantined[target.Identity] = entry
+	delete(p.failures, target.Identity)
+
+	quarantineCtr.WithLabelValues(cfg.Site).Inc()
+	quarantinedGauge.WithLabelValues(cfg.Site).Set(float64(len(p.quarantined)))
+
+	return entry
+}
+
+// expired determines if the last failure in history is older than expiry, histories do not expire when expiry is 0
+func expired(history *failureHistory, now time.Time, expiry time.Duration) bool {
+	return expiry > 0 && now.Sub(history.last) > expiry
+}
+
+// expireFailures forgets the failure histories of nodes that did not fail within quarantine_expiry
+func (p *Provisioner) expireFailures() {
+	expiry := p.currentConfig().QuarantineExpiryDuration
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for identity, history := range p.failures {
+		if expired(history, p.clock.Now(), expiry) {
+			delete(p.failures, identity)
+		}
+	}
+}
+
+// clearFailures forgets the failure history of a node that was provisioned
+func (p *Provisioner) clearFailures(target *host.Host) {
+	p.mu.Lock()
+	delete(p.failures, target.Identity)
+	p.mu.Unlock()
+}
+
+// IsQuarantined determines if identity is quarantined
+func (p *Provisioner) IsQuarantined(identity string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.quarantined[identity]
+	return ok
+}
+
+// Quarantined lists the quarantined nodes sorted by when they were quarantined
+func (p *Provisioner) Quarantined() []QuarantineEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries := []QuarantineEntry{}
+	for _, e := range p.quarantined {
+		entries = append(entries, *e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Since.Before(entries[j].Since)
+	})
+
+	return entries
+}
+
+// Release removes identity from quarantine so it will be provisioned when found again, returns false if it was not quarantined
+func (p *Provisioner) Release(identity string) bool {
+	p.mu.Lock()
+	_, ok := p.quarantined[identity]
+	if ok {
+		delete(p.quarantined, identity)
+		quarantinedGauge.WithLabelValues(p.currentConfig().Site).Set(float64(len(p.quarantined)))
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	if p.quarantine != nil {
+		err := p.quarantine.Delete(identity)
+		if err != nil {
+			p.log.Errorf("Could not remove %s from the persisted quarantine: %s", identity, err)
+		}
+	}
+
+	return true
+}
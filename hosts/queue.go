@@ -0,0 +1,382 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/providers/kv"
+	"github.com/choria-io/provisioner/config"
+	"github.com/choria-io/provisioner/host"
+	"github.com/nats-io/nats.go"
+)
+
+// QueueEntry is a host waiting to be provisioned as recorded in a durable queue
+type QueueEntry struct {
+	Identity   string    `json:"identity"`
+	Discovered time.Time `json:"discovered"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+	Event      bool      `json:"event,omitempty"`
+	Priority   *int      `json:"priority,omitempty"`
+}
+
+// QueueStore persists the hosts waiting to be provisioned so they survive restarts
+type QueueStore interface {
+	Put(entry *QueueEntry) error
+	Delete(identity string) error
+	List() ([]*QueueEntry, error)
+}
+
+// queueKey encodes identity so it is safe to use as a file name or bucket key
+func queueKey(identity string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(identity))
+}
+
+func parseQueueEntry(j []byte) (*QueueEntry, error) {
+	entry := &QueueEntry{}
+	err := json.Unmarshal(j, entry)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse queue entry: %s", err)
+	}
+
+	return entry, nil
+}
+
+// DirectoryQueueStore persists the queue as a JSON file per host in a directory
+type DirectoryQueueStore struct {
+	dir string
+}
+
+// NewDirectoryQueueStore creates a store that persists the queue in dir, the directory is created when missing
+func NewDirectoryQueueStore(dir string) (*DirectoryQueueStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("could not create queue directory: %s", err)
+	}
+
+	return &DirectoryQueueStore{dir: dir}, nil
+}
+
+func (s *DirectoryQueueStore) file(identity string) string {
+	return filepath.Join(s.dir, queueKey(identity)+".json")
+}
+
+// Put records entry, the file is replaced atomically
+func (s *DirectoryQueueStore) Put(entry *QueueEntry) error {
+	j, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tf, err := os.CreateTemp(s.dir, ".entry.*")
+	if err != nil {
+		return fmt.Errorf("could not save queue entry: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	_, err = tf.Write(j)
+	tf.Close()
+	if err != nil {
+		return fmt.Errorf("could not save queue entry: %s", err)
+	}
+
+	err = os.Rename(tf.Name(), s.file(entry.Identity))
+	if err != nil {
+		return fmt.Errorf("could not save queue entry: %s", err)
+	}
+
+	return nil
+}
+
+// Delete removes the entry for identity, unknown identities are ignored
+func (s *DirectoryQueueStore) Delete(identity string) error {
+	err := os.Remove(s.file(identity))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not delete queue entry: %s", err)
+	}
+
+	return nil
+}
+
+// List reads all entries
+func (s *DirectoryQueueStore) List() ([]*QueueEntry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read queue directory: %s", err)
+	}
+
+	var entries []*QueueEntry
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+
+		j, err := os.ReadFile(filepath.Join(s.dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read queue entry: %s", err)
+		}
+
+		entry, err := parseQueueEntry(j)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name(), err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// kvQueueStore persists the queue in a Choria Key-Value bucket shared by all instances
+type kvQueueStore struct {
+	kv nats.KeyValue
+}
+
+func (s *kvQueueStore) Put(entry *QueueEntry) error {
+	j, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Put(queueKey(entry.Identity), j)
+	if err != nil {
+		return fmt.Errorf("could not save queue entry: %s", err)
+	}
+
+	return nil
+}
+
+func (s *kvQueueStore) Delete(identity string) error {
+	err := s.kv.Delete(queueKey(identity))
+	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return fmt.Errorf("could not delete queue entry: %s", err)
+	}
+
+	return nil
+}
+
+func (s *kvQueueStore) List() ([]*QueueEntry, error) {
+	keys, err := s.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list queue entries: %s", err)
+	}
+
+	var entries []*QueueEntry
+	for _, key := range keys {
+		kve, err := s.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not load queue entry: %s", err)
+		}
+
+		entry, err := parseQueueEntry(kve.Value())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// setupQueueStore configures the durable queue unless a store was supplied as an option
+func (p *Provisioner) setupQueueStore(ctx context.Context, conn inter.Connector, cfg *config.Config) error {
+	var err error
+
+	switch {
+	case p.queue != nil:
+		return nil
+
+	case cfg.QueueDirectory != "":
+		p.log.Infof("Persisting the work queue in %s", cfg.QueueDirectory)
+		p.queue, err = NewDirectoryQueueStore(cfg.QueueDirectory)
+		if err != nil {
+			return err
+		}
+
+	case cfg.QueueBucket != "":
+		p.log.Infof("Persisting the work queue in the %s Key-Value bucket", cfg.QueueBucket)
+		bucket, err := p.fw.KV(ctx, conn, cfg.QueueBucket, true, kv.WithHistory(1))
+		if err != nil {
+			return fmt.Errorf("could not access queue bucket %s: %s", cfg.QueueBucket, err)
+		}
+
+		p.queue = &kvQueueStore{kv: bucket}
+	}
+
+	return nil
+}
+
+// restoreQueue adds hosts from the durable queue to the work queue, entries older than the staleness cutoff are dropped
+func (p *Provisioner) restoreQueue() error {
+	if p.queue == nil {
+		return nil
+	}
+
+	entries, err := p.queue.List()
+	if err != nil {
+		return err
+	}
+
+	cfg := p.currentConfig()
+	restored := 0
+
+	for _, entry := range entries {
+		if p.clock.Now().Sub(entry.Discovered) > cfg.Timeouts.StaleDuration {
+			p.log.Infof("Dropping %s from the work queue, it was discovered at %v", entry.Identity, entry.Discovered)
+
+			err = p.queue.Delete(entry.Identity)
+			if err != nil {
+				p.log.Errorf("Could not remove stale queue entry: %s", err)
+			}
+
+			continue
+		}
+
+		h := p.newHostAt(entry.Identity, entry.Discovered)
+		h.RestoreAttempts(entry.Attempts, entry.LastError)
+		if entry.Event {
+			h.MarkEventSourced()
+		}
+		if entry.Priority != nil {
+			h.SetPriority(*entry.Priority)
+		}
+
+		if p.add(h) {
+			restored++
+		}
+	}
+
+	if restored > 0 {
+		p.log.Infof("Restored %d hosts from the durable work queue", restored)
+	}
+
+	return nil
+}
+
+// queueWriter applies changes to the durable queue without holding the provisioner lock, pending
+// changes are combined per host so only the most recent change to each host is written
+type queueWriter struct {
+	// pending maps identities to the entry to write, nil entries are deleted
+	pending map[string]*QueueEntry
+	changed chan struct{}
+	mu      sync.Mutex
+	// wmu serializes writes so changes to a host are applied in the order they were made
+	wmu sync.Mutex
+}
+
+func newQueueWriter() *queueWriter {
+	return &queueWriter{
+		pending: make(map[string]*QueueEntry),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// set records the change to identity, a nil entry removes it
+func (w *queueWriter) set(identity string, entry *QueueEntry) {
+	w.mu.Lock()
+	w.pending[identity] = entry
+	w.mu.Unlock()
+
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// writeQueue writes changes to the durable queue as they are made until ctx is done
+func (p *Provisioner) writeQueue(ctx context.Context) {
+	for {
+		select {
+		case <-p.queueWrites.changed:
+			p.flushQueue()
+
+		case <-ctx.Done():
+			p.flushQueue()
+			return
+		}
+	}
+}
+
+// flushQueue writes all pending changes to the durable queue
+func (p *Provisioner) flushQueue() {
+	w := p.queueWrites
+
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = make(map[string]*QueueEntry)
+	w.mu.Unlock()
+
+	if p.queue == nil {
+		return
+	}
+
+	for identity, entry := range pending {
+		if entry == nil {
+			err := p.queue.Delete(identity)
+			if err != nil {
+				p.log.Errorf("Could not remove %s from the work queue: %s", identity, err)
+			}
+			continue
+		}
+
+		err := p.queue.Put(entry)
+		if err != nil {
+			p.log.Errorf("Could not persist %s in the work queue: %s", identity, err)
+		}
+	}
+}
+
+// persist records the host in the durable queue, the write happens in the background
+func (p *Provisioner) persist(h *host.Host) {
+	if p.queue == nil {
+		return
+	}
+
+	attempts, lastError := h.Attempts()
+	entry := &QueueEntry{
+		Identity:   h.Identity,
+		Discovered: h.DiscoveredTime(),
+		Attempts:   attempts,
+		LastError:  lastError,
+		Event:      h.EventSourced(),
+	}
+
+	priority, known := h.Priority()
+	if known {
+		entry.Priority = &priority
+	}
+
+	p.queueWrites.set(h.Identity, entry)
+}
+
+// forget removes the host from the durable queue, the write happens in the background
+func (p *Provisioner) forget(h *host.Host) {
+	if p.queue == nil {
+		return
+	}
+
+	p.queueWrites.set(h.Identity, nil)
+}
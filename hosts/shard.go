@@ -0,0 +1,268 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/go-choria/providers/kv"
+	"github.com/choria-io/provisioner/config"
+	"github.com/nats-io/nats.go"
+)
+
+// shardReplicas is how many points each member has on the hash ring, more points spread nodes more evenly
+const shardReplicas = 128
+
+// MembershipStore records the instances taking part in a sharded deployment
+type MembershipStore interface {
+	// Join records member as alive, it is called every heartbeat
+	Join(member string) error
+	// Leave removes member
+	Leave(member string) error
+	// Members lists the members that joined within maxAge
+	Members(maxAge time.Duration) ([]string, error)
+}
+
+type membership struct {
+	Identity string    `json:"identity"`
+	Seen     time.Time `json:"seen"`
+}
+
+// kvMembershipStore records members in a Key-Value bucket
+type kvMembershipStore struct {
+	kv nats.KeyValue
+}
+
+func (s *kvMembershipStore) Join(member string) error {
+	j, err := json.Marshal(membership{Identity: member, Seen: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Put(queueKey(member), j)
+
+	return err
+}
+
+func (s *kvMembershipStore) Leave(member string) error {
+	return s.kv.Delete(queueKey(member))
+}
+
+func (s *kvMembershipStore) Members(maxAge time.Duration) ([]string, error) {
+	keys, err := s.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var members []string
+	for _, key := range keys {
+		entry, err := s.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if time.Since(entry.Created()) > maxAge {
+			continue
+		}
+
+		var m membership
+		err = json.Unmarshal(entry.Value(), &m)
+		if err != nil || m.Identity == "" {
+			continue
+		}
+
+		members = append(members, m.Identity)
+	}
+
+	return members, nil
+}
+
+// shardRing assigns identities to members using consistent hashing so only a share of the nodes move when members join or leave
+type shardRing struct {
+	points []uint64
+	owners map[uint64]string
+}
+
+func newShardRing(members []string) *shardRing {
+	ring := &shardRing{owners: make(map[uint64]string)}
+
+	for _, member := range members {
+		for i := 0; i < shardReplicas; i++ {
+			point := shardHash(fmt.Sprintf("%s#%d", member, i))
+			ring.points = append(ring.points, point)
+			ring.owners[point] = member
+		}
+	}
+
+	slices.Sort(ring.points)
+
+	return ring
+}
+
+// owner is the member that provisions identity
+func (r *shardRing) owner(identity string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	i, _ := slices.BinarySearch(r.points, shardHash(identity))
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.owners[r.points[i]]
+}
+
+// shardHash hashes s onto the ring, similar identities like node1 and node2 are spread evenly
+func shardHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// setupSharding joins the members sharing the nodes and keeps the membership current until ctx is done
+func (p *Provisioner) setupSharding(ctx context.Context, conn inter.Connector, cfg *config.Config) error {
+	if !cfg.Sharding.Enabled() {
+		return nil
+	}
+
+	if p.member == "" {
+		p.member = p.fw.Certname()
+	}
+
+	if p.members == nil {
+		p.log.Infof("Sharding nodes with other members of the %s Key-Value bucket", cfg.Sharding.Bucket)
+		bucket, err := p.fw.KV(ctx, conn, cfg.Sharding.Bucket, true, kv.WithHistory(1), kv.WithTTL(3*cfg.Sharding.HeartbeatDuration))
+		if err != nil {
+			return fmt.Errorf("could not access sharding bucket %s: %s", cfg.Sharding.Bucket, err)
+		}
+
+		p.members = &kvMembershipStore{kv: bucket}
+	}
+
+	err := p.heartbeat()
+	if err != nil {
+		return err
+	}
+
+	p.wg.Add(1)
+	go p.shard(ctx, cfg.Sharding.HeartbeatDuration)
+
+	return nil
+}
+
+func (p *Provisioner) shard(ctx context.Context, interval time.Duration) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := p.heartbeat()
+			if err != nil {
+				p.log.Errorf("Could not update shard membership: %s", err)
+			}
+
+		case <-ctx.Done():
+			err := p.members.Leave(p.member)
+			if err != nil {
+				p.log.Warnf("Could not leave the shard members: %s", err)
+			}
+
+			return
+		}
+	}
+}
+
+// heartbeat renews the membership of this instance and rebalances when the members changed
+func (p *Provisioner) heartbeat() error {
+	cfg := p.currentConfig()
+
+	err := p.members.Join(p.member)
+	if err != nil {
+		return fmt.Errorf("could not renew membership: %s", err)
+	}
+
+	members, err := p.members.Members(3 * cfg.Sharding.HeartbeatDuration)
+	if err != nil {
+		return fmt.Errorf("could not list members: %s", err)
+	}
+
+	if !slices.Contains(members, p.member) {
+		members = append(members, p.member)
+	}
+
+	slices.Sort(members)
+	members = slices.Compact(members)
+
+	p.setMembers(members)
+
+	return nil
+}
+
+// setMembers rebuilds the hash ring when members changed, queued nodes now owned by other members are dropped and a discovery finds newly owned ones
+func (p *Provisioner) setMembers(members []string) {
+	p.mu.Lock()
+
+	if slices.Equal(p.shardMembers, members) {
+		p.mu.Unlock()
+		return
+	}
+
+	p.shardMembers = members
+	p.ring = newShardRing(members)
+
+	dropped := 0
+	for identity, h := range p.hosts {
+		_, busy := p.busy[identity]
+		if busy || p.ownsUnlocked(identity) {
+			continue
+		}
+
+		p.removeUnlocked(h)
+		dropped++
+	}
+
+	p.mu.Unlock()
+
+	shardMembersGauge.WithLabelValues(p.currentConfig().Site).Set(float64(len(members)))
+	p.log.Warnf("Sharding nodes between %d members %v, handed %d queued nodes to other members", len(members), members, dropped)
+
+	p.TriggerDiscovery()
+}
+
+// owner is the member that provisions identity, true when it is this instance
+func (p *Provisioner) owner(identity string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ring == nil {
+		return p.member, true
+	}
+
+	owner := p.ring.owner(identity)
+
+	return owner, owner == p.member
+}
+
+func (p *Provisioner) ownsUnlocked(identity string) bool {
+	return p.ring == nil || p.ring.owner(identity) == p.member
+}
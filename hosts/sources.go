@@ -0,0 +1,230 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/choria-io/go-choria/inter"
+	"github.com/choria-io/provisioner/config"
+	"github.com/nats-io/nats.go"
+	"github.com/tidwall/gjson"
+)
+
+// newDiscoverySources creates the discovery sources set in the configuration
+func (p *Provisioner) newDiscoverySources(ctx context.Context, conn inter.Connector, cfg *config.Config) ([]DiscoverySource, error) {
+	var sources []DiscoverySource
+
+	for _, sc := range cfg.DiscoverySources {
+		switch sc.Type {
+		case config.DiscoveryBroadcast:
+			sources = append(sources, &broadcastDiscovery{fw: p.fw, name: sc.Name})
+
+		case config.DiscoveryFile:
+			sources = append(sources, &fileDiscovery{name: sc.Name, path: sc.Path})
+
+		case config.DiscoveryHTTP:
+			sources = append(sources, &httpDiscovery{
+				name:    sc.Name,
+				url:     sc.URL,
+				headers: sc.Headers,
+				query:   sc.Query,
+				client:  &http.Client{Timeout: sc.TimeoutDuration},
+			})
+
+		case config.DiscoveryRegistry:
+			bucket, err := p.fw.KV(ctx, conn, sc.Bucket, false)
+			if err != nil {
+				return nil, fmt.Errorf("could not access registry bucket %s: %s", sc.Bucket, err)
+			}
+
+			sources = append(sources, &registryDiscovery{name: sc.Name, kv: bucket, maxAge: sc.MaxAgeDuration})
+
+		default:
+			return nil, fmt.Errorf("unknown discovery source type %q", sc.Type)
+		}
+
+		p.log.Infof("Discovering nodes using the %s %s source", sc.Name, sc.Type)
+	}
+
+	return sources, nil
+}
+
+// fileDiscovery reads identities from a file with one identity per line or uses the names of the files in a directory as identities
+type fileDiscovery struct {
+	name string
+	path string
+}
+
+func (d *fileDiscovery) Name() string { return d.name }
+
+func (d *fileDiscovery) Discover(_ context.Context, _ *config.Config) ([]string, error) {
+	stat, err := os.Stat(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.IsDir() {
+		return d.discoverDirectory()
+	}
+
+	return d.discoverFile()
+}
+
+func (d *fileDiscovery) discoverDirectory() ([]string, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		nodes = append(nodes, entry.Name())
+	}
+
+	return nodes, nil
+}
+
+func (d *fileDiscovery) discoverFile() ([]string, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var nodes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		nodes = append(nodes, line)
+	}
+
+	return nodes, scanner.Err()
+}
+
+// httpDiscovery fetches a JSON list of identities from a HTTP endpoint
+type httpDiscovery struct {
+	name    string
+	url     string
+	headers map[string]string
+	query   string
+	client  *http.Client
+}
+
+func (d *httpDiscovery) Name() string { return d.name }
+
+func (d *httpDiscovery) Discover(ctx context.Context, _ *config.Config) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	for k, v := range d.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", d.url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 100*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseIdentityList(body, d.query)
+}
+
+// parseIdentityList extracts a list of identities from JSON data, query selects the list when set
+func parseIdentityList(data []byte, query string) ([]string, error) {
+	if !gjson.ValidBytes(data) {
+		return nil, fmt.Errorf("invalid JSON data")
+	}
+
+	list := gjson.ParseBytes(data)
+	if query != "" {
+		list = list.Get(query)
+	}
+
+	if !list.IsArray() {
+		return nil, fmt.Errorf("expected a list of identities")
+	}
+
+	var nodes []string
+	for _, node := range list.Array() {
+		if node.Type != gjson.String || node.String() == "" {
+			return nil, fmt.Errorf("expected a list of identities but found %s", node.Raw)
+		}
+
+		nodes = append(nodes, node.String())
+	}
+
+	return nodes, nil
+}
+
+// registryDiscovery uses the keys of a Key-Value bucket maintained by a node registry as identities
+type registryDiscovery struct {
+	name   string
+	kv     nats.KeyValue
+	maxAge time.Duration
+}
+
+func (d *registryDiscovery) Name() string { return d.name }
+
+func (d *registryDiscovery) Discover(_ context.Context, _ *config.Config) ([]string, error) {
+	keys, err := d.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if d.maxAge == 0 {
+		return keys, nil
+	}
+
+	var nodes []string
+	for _, key := range keys {
+		entry, err := d.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if time.Since(entry.Created()) > d.maxAge {
+			continue
+		}
+
+		nodes = append(nodes, key)
+	}
+
+	return nodes, nil
+}
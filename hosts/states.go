@@ -0,0 +1,61 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+
+	"github.com/choria-io/provisioner/host"
+)
+
+// stateChanged requests an update of the hosts in state gauge without blocking
+func (p *Provisioner) stateChanged() {
+	select {
+	case p.statesChanged <- struct{}{}:
+	default:
+	}
+}
+
+// watchStates updates the hosts in state gauge whenever hosts change state
+func (p *Provisioner) watchStates(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.statesChanged:
+			p.updateStates()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// countStates counts the known hosts in every state
+func (p *Provisioner) countStates() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := make(map[string]int, len(host.States))
+	for _, s := range host.States {
+		counts[s] = 0
+	}
+
+	for _, h := range p.hosts {
+		state, _ := h.State()
+		counts[state]++
+	}
+
+	return counts
+}
+
+// updateStates sets the hosts in state gauge for every state
+func (p *Provisioner) updateStates() {
+	site := p.currentConfig().Site
+
+	for state, count := range p.countStates() {
+		hostsInStateGauge.WithLabelValues(site, state).Set(float64(count))
+	}
+}
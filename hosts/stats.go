@@ -17,6 +17,11 @@ var (
 		Help: "How many nodes were found through receiving an event about them",
 	}, []string{"site"})
 
+	lifecycleEventsCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_lifecycle_events",
+		Help: "How many lifecycle events were received by type",
+	}, []string{"site", "type"})
+
 	discoverCycleCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "choria_provisioner_discover_cycles",
 		Help: "How many discovery cycles were ran",
@@ -27,11 +32,96 @@ var (
 		Help: "How many discovery related errors were encountered",
 	}, []string{"site"})
 
+	sourceNodesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "choria_provisioner_discovery_source_nodes",
+		Help: "How many nodes each discovery source found during the last discovery",
+	}, []string{"site", "source"})
+
+	sourceErrCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_discovery_source_errors",
+		Help: "How many times each discovery source failed",
+	}, []string{"site", "source"})
+
+	shardMembersGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "choria_provisioner_shard_members",
+		Help: "How many provisioners are sharing the nodes",
+	}, []string{"site"})
+
+	lockedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_locked",
+		Help: "How many times a node was skipped because another provisioner holds its lock",
+	}, []string{"site"})
+
+	lockErrCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_lock_errors",
+		Help: "How many times a node could not be locked",
+	}, []string{"site"})
+
+	abandonedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_abandoned",
+		Help: "How many nodes were abandoned while being provisioned because they did not finish within timeouts.drain on shutdown",
+	}, []string{"site"})
+
+	dryRunCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_dry_run",
+		Help: "How many nodes were planned without being changed in dry run mode",
+	}, []string{"site"})
+
+	auditErrCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_audit_errors",
+		Help: "How many provisioning attempts could not be written to the audit log",
+	}, []string{"site"})
+
+	eventErrCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_event_errors",
+		Help: "How many node events could not be published",
+	}, []string{"site"})
+
+	webhookDeliveredCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_webhook_deliveries",
+		Help: "How many webhooks were delivered",
+	}, []string{"site", "endpoint"})
+
+	webhookFailedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_webhook_failures",
+		Help: "How many webhook delivery attempts failed",
+	}, []string{"site", "endpoint"})
+
+	webhookDroppedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_webhook_dropped",
+		Help: "How many webhooks were dropped because the queue was full or all attempts failed",
+	}, []string{"site", "endpoint"})
+
+	hostsInStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "choria_provisioner_hosts_in_state",
+		Help: "How many known nodes are in each provisioning state",
+	}, []string{"site", "state"})
+
 	provErrCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "choria_provisioner_provision_errors",
 		Help: "How many provision related errors were encountered",
 	}, []string{"site"})
 
+	retryCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_provision_retries",
+		Help: "How many times provisioning a failed node was scheduled to be retried",
+	}, []string{"site"})
+
+	retriesExhaustedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_provision_retries_exhausted",
+		Help: "How many nodes were given up on after reaching the maximum attempts",
+	}, []string{"site"})
+
+	quarantineCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "choria_provisioner_quarantined",
+		Help: "How many nodes were quarantined after failing too often",
+	}, []string{"site"})
+
+	quarantinedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "choria_provisioner_quarantined_nodes",
+		Help: "The number of nodes currently quarantined",
+	}, []string{"site"})
+
 	busyWorkerGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "choria_provisioner_busy_workers",
 		Help: "How many workers are busy provisioning nodes",
@@ -56,9 +146,27 @@ var (
 func init() {
 	prometheus.MustRegister(discoveredCtr)
 	prometheus.MustRegister(eventsCtr)
+	prometheus.MustRegister(lifecycleEventsCtr)
 	prometheus.MustRegister(discoverCycleCtr)
 	prometheus.MustRegister(errCtr)
+	prometheus.MustRegister(sourceNodesGauge)
+	prometheus.MustRegister(sourceErrCtr)
+	prometheus.MustRegister(shardMembersGauge)
+	prometheus.MustRegister(lockedCtr)
+	prometheus.MustRegister(lockErrCtr)
+	prometheus.MustRegister(abandonedCtr)
+	prometheus.MustRegister(dryRunCtr)
+	prometheus.MustRegister(auditErrCtr)
+	prometheus.MustRegister(eventErrCtr)
+	prometheus.MustRegister(webhookDeliveredCtr)
+	prometheus.MustRegister(webhookFailedCtr)
+	prometheus.MustRegister(webhookDroppedCtr)
+	prometheus.MustRegister(hostsInStateGauge)
 	prometheus.MustRegister(provErrCtr)
+	prometheus.MustRegister(retryCtr)
+	prometheus.MustRegister(retriesExhaustedCtr)
+	prometheus.MustRegister(quarantineCtr)
+	prometheus.MustRegister(quarantinedGauge)
 	prometheus.MustRegister(busyWorkerGauge)
 	prometheus.MustRegister(provisionedCtr)
 	prometheus.MustRegister(waitingGauge)
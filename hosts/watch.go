@@ -0,0 +1,66 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// bucketWatchRetry is how long to wait before watching a bucket again after a watch ended
+var bucketWatchRetry = 5 * time.Second
+
+// watchBucket passes the current values of keys in bucket and any later changes to apply until ctx is done, the
+// watch is established again when it ends, for example after the connection was lost
+func (p *Provisioner) watchBucket(ctx context.Context, bucket nats.KeyValue, keys string, what string, apply func(nats.KeyValueEntry)) {
+	defer p.wg.Done()
+
+	for {
+		err := p.followBucket(ctx, bucket, keys, apply)
+		if ctx.Err() != nil {
+			return
+		}
+
+		p.log.Errorf("Watching %s failed, retrying in %v: %s", what, bucketWatchRetry, err)
+
+		select {
+		case <-time.After(bucketWatchRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// followBucket passes the current values and any later changes to apply until the watch ends
+func (p *Provisioner) followBucket(ctx context.Context, bucket nats.KeyValue, keys string, apply func(nats.KeyValueEntry)) error {
+	// the current values are included so changes missed while not watching are applied
+	watch, err := bucket.Watch(keys, nats.Context(ctx))
+	if err != nil {
+		return err
+	}
+	defer watch.Stop()
+
+	for {
+		select {
+		case entry, ok := <-watch.Updates():
+			if !ok {
+				return fmt.Errorf("watch ended")
+			}
+
+			// nil marks the end of the initial values
+			if entry == nil {
+				continue
+			}
+
+			apply(entry)
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
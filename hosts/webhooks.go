@@ -0,0 +1,369 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/choria-io/provisioner/config"
+	"github.com/choria-io/provisioner/host"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// WebhookPayload is the JSON body posted to webhook endpoints
+type WebhookPayload struct {
+	ID          string    `json:"id"`
+	Time        time.Time `json:"time"`
+	Identity    string    `json:"identity"`
+	Outcome     string    `json:"outcome"`
+	Site        string    `json:"site,omitempty"`
+	Provisioner string    `json:"provisioner,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// webhookEndpoint has its own queue so a slow or failing endpoint does not hold up deliveries to others
+type webhookEndpoint struct {
+	config.WebhookEndpoint
+
+	identities []*regexp.Regexp
+	queue      chan *webhookDelivery
+}
+
+// wants determines if the endpoint should receive outcome for identity
+func (e *webhookEndpoint) wants(outcome string, identity string) bool {
+	if len(e.Outcomes) > 0 && !slices.Contains(e.Outcomes, outcome) {
+		return false
+	}
+
+	if len(e.identities) == 0 {
+		return true
+	}
+
+	for _, re := range e.identities {
+		if re.MatchString(identity) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type webhookDelivery struct {
+	endpoint *webhookEndpoint
+	payload  *WebhookPayload
+	body     []byte
+	attempt  int
+}
+
+// webhookSender posts payloads from a bounded queue per endpoint so slow endpoints never hold up provisioning
+type webhookSender struct {
+	endpoints []*webhookEndpoint
+	client    *http.Client
+	attempts  int
+	site      string
+	delay     func(attempt int) time.Duration
+	log       *logrus.Entry
+
+	// retries are deliveries waiting to be tried again
+	retries  map[*webhookDelivery]*time.Timer
+	stopping chan struct{}
+	stopped  bool
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+func newWebhookSender(cfg config.Webhooks, site string, log *logrus.Entry) (*webhookSender, error) {
+	sender := &webhookSender{
+		client:   &http.Client{Timeout: cfg.TimeoutDuration},
+		attempts: cfg.Attempts,
+		site:     site,
+		delay:    func(attempt int) time.Duration { return time.Duration(1<<attempt) * time.Second },
+		log:      log,
+		retries:  make(map[*webhookDelivery]*time.Timer),
+		stopping: make(chan struct{}),
+	}
+
+	for _, e := range cfg.Endpoints {
+		endpoint := &webhookEndpoint{WebhookEndpoint: e, queue: make(chan *webhookDelivery, cfg.QueueSize)}
+
+		for _, pattern := range e.Identities {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid identities pattern %q for webhook endpoint %s: %s", pattern, e.Name, err)
+			}
+			endpoint.identities = append(endpoint.identities, re)
+		}
+
+		sender.endpoints = append(sender.endpoints, endpoint)
+	}
+
+	return sender, nil
+}
+
+// send queues payload for every endpoint that wants it
+func (s *webhookSender) send(payload *WebhookPayload) {
+	var body []byte
+
+	for _, endpoint := range s.endpoints {
+		if !endpoint.wants(payload.Outcome, payload.Identity) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			body, err = json.Marshal(payload)
+			if err != nil {
+				s.log.Errorf("Could not encode webhook payload for %s: %s", payload.Identity, err)
+				return
+			}
+		}
+
+		s.enqueue(&webhookDelivery{endpoint: endpoint, payload: payload, body: body})
+	}
+}
+
+// enqueue adds d to the queue of its endpoint without blocking, it is dropped when the queue is full or the sender stopped
+func (s *webhookSender) enqueue(d *webhookDelivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		s.drop(d, "the sender stopped")
+		return
+	}
+
+	s.put(d)
+}
+
+func (s *webhookSender) put(d *webhookDelivery) {
+	select {
+	case d.endpoint.queue <- d:
+	default:
+		s.drop(d, "the queue is full")
+	}
+}
+
+func (s *webhookSender) drop(d *webhookDelivery, reason string) {
+	webhookDroppedCtr.WithLabelValues(s.site, d.endpoint.Name).Inc()
+	s.log.Errorf("Dropping %s webhook for %s to %s, %s", d.payload.Outcome, d.payload.Identity, d.endpoint.Name, reason)
+}
+
+// start delivers queued payloads to every endpoint in parallel until stopped or ctx is done
+func (s *webhookSender) start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	for _, endpoint := range s.endpoints {
+		s.wg.Add(1)
+		go s.runEndpoint(ctx, endpoint)
+	}
+}
+
+// stop delivers what is queued or waiting to be retried for up to grace, deliveries that fail meanwhile are not
+// retried and those still queued after grace are dropped
+func (s *webhookSender) stop(grace time.Duration) {
+	s.mu.Lock()
+	s.stopped = true
+	retries := s.retries
+	s.retries = make(map[*webhookDelivery]*time.Timer)
+
+	// a retry that already fired is dropped by enqueue
+	for d, timer := range retries {
+		if timer.Stop() {
+			s.put(d)
+		}
+	}
+	s.mu.Unlock()
+
+	close(s.stopping)
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+		return
+	case <-timer.C:
+	}
+
+	s.log.Errorf("Could not deliver all webhooks within %v, dropping what is left", grace)
+
+	// canceling fails the deliveries in progress, counting them as dropped
+	s.cancel()
+	<-finished
+
+	// deliveries are not queued once stopped so nothing is added to the queues meanwhile
+	for _, endpoint := range s.endpoints {
+		for len(endpoint.queue) > 0 {
+			s.drop(<-endpoint.queue, "it could not be delivered while shutting down")
+		}
+	}
+}
+
+// runEndpoint delivers payloads queued for endpoint one at a time until ctx is done, once stopping
+// it delivers what is left in the queue and exits
+func (s *webhookSender) runEndpoint(ctx context.Context, endpoint *webhookEndpoint) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case d := <-endpoint.queue:
+			s.deliver(ctx, d)
+
+		case <-s.stopping:
+			for {
+				select {
+				case d := <-endpoint.queue:
+					s.deliver(ctx, d)
+				default:
+					return
+				}
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver posts d and schedules another attempt when it fails, it is dropped once it used all its attempts
+func (s *webhookSender) deliver(ctx context.Context, d *webhookDelivery) {
+	d.attempt++
+
+	err := s.post(ctx, d)
+	if err == nil {
+		webhookDeliveredCtr.WithLabelValues(s.site, d.endpoint.Name).Inc()
+		return
+	}
+
+	webhookFailedCtr.WithLabelValues(s.site, d.endpoint.Name).Inc()
+
+	if d.attempt >= s.attempts {
+		s.drop(d, fmt.Sprintf("giving up after %d attempts: %s", d.attempt, err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		s.drop(d, fmt.Sprintf("not retrying while shutting down: %s", err))
+		return
+	}
+
+	delay := s.delay(d.attempt)
+	s.log.Warnf("Could not post %s webhook for %s to %s, retrying in %v: %s", d.payload.Outcome, d.payload.Identity, d.endpoint.Name, delay, err)
+
+	s.retries[d] = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		delete(s.retries, d)
+		s.mu.Unlock()
+
+		s.enqueue(d)
+	})
+}
+
+func (s *webhookSender) post(ctx context.Context, d *webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Choria-Delivery", d.payload.ID)
+	req.Header.Set("X-Choria-Outcome", d.payload.Outcome)
+
+	if d.endpoint.Secret != "" {
+		req.Header.Set("X-Choria-Signature", "sha256="+webhookSignature(d.endpoint.Secret, d.body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("received %s", resp.Status)
+	}
+
+	return nil
+}
+
+// webhookSignature is the hex encoded HMAC-SHA256 of body using secret
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setupWebhooks prepares the webhook sender when endpoints are configured
+func (p *Provisioner) setupWebhooks(cfg *config.Config) error {
+	if !cfg.Webhooks.Enabled() {
+		return nil
+	}
+
+	sender, err := newWebhookSender(cfg.Webhooks, cfg.Site, p.log)
+	if err != nil {
+		return err
+	}
+
+	p.log.Infof("Posting provisioning outcomes to %d webhook endpoints", len(sender.endpoints))
+	p.webhooks = sender
+
+	return nil
+}
+
+// flushWebhooks delivers the webhooks still queued or waiting to be retried, waiting up to timeouts.drain
+func (p *Provisioner) flushWebhooks() {
+	if p.webhooks == nil {
+		return
+	}
+
+	p.webhooks.stop(p.currentConfig().Timeouts.DrainDuration)
+}
+
+// postWebhook queues outcome for target to the webhook endpoints that want it
+func (p *Provisioner) postWebhook(target *host.Host, outcome string, err error) {
+	if p.webhooks == nil {
+		return
+	}
+
+	payload := &WebhookPayload{
+		ID:          uuid.NewString(),
+		Time:        p.clock.Now().UTC(),
+		Identity:    target.Identity,
+		Outcome:     outcome,
+		Site:        p.currentConfig().Site,
+		Provisioner: p.member,
+	}
+
+	if err != nil {
+		payload.Error = err.Error()
+	}
+
+	p.webhooks.send(payload)
+}
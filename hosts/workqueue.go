@@ -0,0 +1,205 @@
+// Copyright (c) 2026, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package hosts
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/choria-io/provisioner/config"
+	"github.com/choria-io/provisioner/host"
+)
+
+type workItem struct {
+	host *host.Host
+	seq  uint64
+}
+
+// workHeap implements heap.Interface, items that compare equal are kept in the order they were added
+type workHeap struct {
+	items []*workItem
+	less  func(a, b *host.Host) bool
+}
+
+func (w *workHeap) Len() int      { return len(w.items) }
+func (w *workHeap) Swap(i, j int) { w.items[i], w.items[j] = w.items[j], w.items[i] }
+func (w *workHeap) Push(x any)    { w.items = append(w.items, x.(*workItem)) }
+func (w *workHeap) Less(i, j int) bool {
+	a, b := w.items[i], w.items[j]
+
+	switch {
+	case w.less(a.host, b.host):
+		return true
+	case w.less(b.host, a.host):
+		return false
+	default:
+		return a.seq < b.seq
+	}
+}
+
+func (w *workHeap) Pop() any {
+	last := len(w.items) - 1
+	item := w.items[last]
+	w.items[last] = nil
+	w.items = w.items[:last]
+
+	return item
+}
+
+// workQueue is a bounded priority queue of hosts waiting to be provisioned
+type workQueue struct {
+	heap  *workHeap
+	max   int
+	seq   uint64
+	ready chan struct{}
+	mu    sync.Mutex
+}
+
+func newWorkQueue(max int, cfg *config.Config) *workQueue {
+	return &workQueue{
+		heap:  &workHeap{less: queueOrder(cfg)},
+		max:   max,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// queueOrder creates a function that determines if a should be provisioned before b based on cfg.QueueOrder
+func queueOrder(cfg *config.Config) func(a, b *host.Host) bool {
+	order := cfg.QueueOrder
+	resolving := cfg.UsesPriority()
+
+	return func(a, b *host.Host) bool {
+		for _, key := range order {
+			switch key {
+			case config.QueueOrderPriority:
+				ap, aknown := a.Priority()
+				bp, bknown := b.Priority()
+
+				// hosts are queued again once their priority is known so unknown ones go first to be resolved
+				if resolving && aknown != bknown {
+					return !aknown
+				}
+
+				if ap != bp {
+					return ap > bp
+				}
+
+			case config.QueueOrderEvent:
+				ae, be := a.EventSourced(), b.EventSourced()
+				if ae != be {
+					return ae
+				}
+
+			case config.QueueOrderOldest:
+				at, bt := a.DiscoveredTime(), b.DiscoveredTime()
+				if !at.Equal(bt) {
+					return at.Before(bt)
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// Push adds h to the queue, returns false when the queue is full
+func (q *workQueue) Push(h *host.Host) bool {
+	q.mu.Lock()
+	if q.heap.Len() >= q.max {
+		q.mu.Unlock()
+		return false
+	}
+
+	q.seq++
+	heap.Push(q.heap, &workItem{host: h, seq: q.seq})
+	q.mu.Unlock()
+
+	q.signal()
+
+	return true
+}
+
+// Pop waits for the next host to provision, returns false when ctx is done
+func (q *workQueue) Pop(ctx context.Context) (*host.Host, bool) {
+	for {
+		if ctx.Err() != nil {
+			return nil, false
+		}
+
+		q.mu.Lock()
+		if q.heap.Len() > 0 {
+			item := heap.Pop(q.heap).(*workItem)
+			more := q.heap.Len() > 0
+			q.mu.Unlock()
+
+			// wake another waiting worker for the remaining items
+			if more {
+				q.signal()
+			}
+
+			return item.host, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// Remove removes the queued entry for identity, returns false if it was not queued
+func (q *workQueue) Remove(identity string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, item := range q.heap.items {
+		if item.host.Identity == identity {
+			heap.Remove(q.heap, i)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reorder sorts the queue using the order configured in cfg
+func (q *workQueue) Reorder(cfg *config.Config) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.heap.less = queueOrder(cfg)
+	heap.Init(q.heap)
+}
+
+// Clear removes all entries
+func (q *workQueue) Clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.heap.items = nil
+}
+
+// Len is the number of queued hosts
+func (q *workQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.heap.Len()
+}
+
+// Cap is the most hosts that can be queued
+func (q *workQueue) Cap() int {
+	return q.max
+}
+
+func (q *workQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}